@@ -0,0 +1,561 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/raymondbutcher/remake/colors"
+	"github.com/raymondbutcher/remake/logs"
+	"github.com/raymondbutcher/remake/makecmd"
+	"github.com/raymondbutcher/remake/makedb"
+)
+
+// frequentCheck is the check interval below which Remake warns that
+// the make query runs frequently.
+const frequentCheck = time.Second
+
+var (
+	cacheDatabase  bool
+	checkInterval  time.Duration
+	coalesceBuilds bool
+	detectOnly     bool
+	dryRun         bool
+	dir            string
+	excludeTargets stringList
+	finishCurrent  stringList
+	gitIgnore      bool
+	graceFor       = map[string]time.Duration{}
+	gracePeriod    time.Duration
+	graphChanges   bool
+	historyPath    string
+	httpAddr       string
+	ignore         stringList
+	listMode       bool
+	killTimeout    time.Duration
+	logFormat      string
+	makeArgs       []string
+	makeCaps       makecmd.Capabilities
+	makeName       string
+	maxRestarts    int
+	mtimeBaseline  string
+	noColor        bool
+	noDefault      bool
+	onExit         string
+	onFailure      string
+	onSuccess      string
+	once           bool
+	outputSync     string
+	parallel       int
+	prefix         bool
+	quiet          bool
+	readyCmd       string
+	readyMode      bool
+	readyPattern   *regexp.Regexp
+	readySignal    syscall.Signal
+	readySigName   string
+	recursive      bool
+	settle         time.Duration
+	stableFor      time.Duration
+	targetFile     string
+	verbose        bool
+	versionMode    bool
+	watchDotfiles  bool
+	watchPaths     stringList
+)
+
+// stringList is a flag value that can be specified multiple times.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// Contains reports whether the list contains s.
+func (l *stringList) Contains(s string) bool {
+	for _, item := range *l {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// setGraceFor adds a grace period to graceFor from a -grace-for value.
+func setGraceFor(s string) error {
+	i := strings.LastIndex(s, "=")
+	if i < 1 {
+		return fmt.Errorf("expected target=duration")
+	}
+	d, err := time.ParseDuration(s[i+1:])
+	if err != nil {
+		return err
+	}
+	graceFor[s[:i]] = d
+	return nil
+}
+
+// processArguments parses and validates the command line arguments,
+// and returns the goals to manage.
+func processArguments() (goals []goal) {
+
+	flag.BoolVar(
+		&cacheDatabase,
+		"cache-db",
+		false,
+		"Only run the make query again when the makefiles change",
+	)
+	flag.DurationVar(
+		&checkInterval,
+		"check",
+		2*time.Second,
+		"Interval between checking for changes",
+	)
+	flag.StringVar(
+		&dir,
+		"C",
+		"",
+		"Change to this directory before running make",
+	)
+	flag.StringVar(
+		&dir,
+		"dir",
+		"",
+		"Same as -C",
+	)
+	flag.BoolVar(
+		&coalesceBuilds,
+		"coalesce-builds",
+		false,
+		"Build goals that need building at the same time with one make command",
+	)
+	flag.BoolVar(
+		&detectOnly,
+		"detect-only",
+		false,
+		"Print the names of targets when they change, without running make to build them",
+	)
+	flag.BoolVar(
+		&dryRun,
+		"dry-run",
+		false,
+		"Log which targets need building, without running make to build them",
+	)
+	flag.Var(
+		&excludeTargets,
+		"exclude-target",
+		"Glob pattern for prerequisites to ignore when checking if a target is up to date (repeatable)",
+	)
+	flag.Var(
+		&finishCurrent,
+		"finish-current",
+		"Let a target's running command finish instead of killing it when changes are detected (repeatable)",
+	)
+	flag.BoolVar(
+		&gitIgnore,
+		"gitignore",
+		false,
+		"Skip files ignored by .gitignore files when watching -watch-path",
+	)
+	flag.DurationVar(
+		&gracePeriod,
+		"grace",
+		10*time.Second,
+		"Grace period for commands to finish building",
+	)
+	flag.Func(
+		"grace-for",
+		"Grace period for one target, as target=duration, instead of -grace (repeatable)",
+		setGraceFor,
+	)
+	flag.BoolVar(
+		&graphChanges,
+		"graph-changes",
+		false,
+		"Rebuild when the dependency graph changes, even if no files have changed",
+	)
+	flag.StringVar(
+		&historyPath,
+		"history",
+		"",
+		"File to append a JSON line to after each build",
+	)
+	flag.StringVar(
+		&httpAddr,
+		"http",
+		"",
+		"Address to serve build stats on, such as localhost:8090",
+	)
+	flag.Var(
+		&ignore,
+		"ignore",
+		"Glob pattern for files to skip when watching -watch-path (repeatable)",
+	)
+	flag.DurationVar(
+		&killTimeout,
+		"kill-timeout",
+		2*time.Second,
+		"How long commands have to exit after SIGTERM, before they get SIGKILL",
+	)
+	flag.BoolVar(
+		&listMode,
+		"list",
+		false,
+		"Print the targets in the make database with their status, and then quit",
+	)
+	flag.StringVar(
+		&logFormat,
+		"log-format",
+		"text",
+		"Format of Remake's log messages: text or json",
+	)
+	flag.StringVar(
+		&makeName,
+		"make",
+		"make",
+		"Name or path of the make executable",
+	)
+	flag.IntVar(
+		&maxRestarts,
+		"max-restarts",
+		0,
+		"Back off when a target restarts more than this many times in a minute (0 for no limit)",
+	)
+	flag.StringVar(
+		&mtimeBaseline,
+		"mtime-baseline",
+		string(makecmd.ClockBaseline),
+		"What to compare phony target prerequisites against: clock or newest-prereq",
+	)
+	flag.BoolVar(
+		&noColor,
+		"no-color",
+		false,
+		"Disable colors in log messages",
+	)
+	flag.BoolVar(
+		&noDefault,
+		"no-default",
+		false,
+		"Require a target instead of using the default goal",
+	)
+	flag.StringVar(
+		&onExit,
+		"on-exit",
+		"",
+		"Command to run when Remake is shutting down",
+	)
+	flag.StringVar(
+		&onFailure,
+		"on-failure",
+		"",
+		"Command to run after a build fails",
+	)
+	flag.StringVar(
+		&onSuccess,
+		"on-success",
+		"",
+		"Command to run after a build succeeds",
+	)
+	flag.BoolVar(
+		&once,
+		"once",
+		false,
+		"Build until the targets are up to date, and then quit",
+	)
+	flag.StringVar(
+		&outputSync,
+		"output-sync",
+		"",
+		"Pass --output-sync to make when building: none, line, target or recurse",
+	)
+	flag.IntVar(
+		&parallel,
+		"parallel",
+		0,
+		"How many targets can build at once if they share no dependencies (0 for one per directory)",
+	)
+	flag.BoolVar(
+		&prefix,
+		"prefix",
+		false,
+		"Prefix each line of make output with the goal name",
+	)
+	flag.BoolVar(
+		&quiet,
+		"quiet",
+		false,
+		"Discard the standard output of make, but still show errors",
+	)
+	flag.StringVar(
+		&readyCmd,
+		"ready-cmd",
+		"",
+		"Command to run during the grace period, which signals readiness when it succeeds",
+	)
+	flag.Func(
+		"ready-pattern",
+		"Regular expression matching make output, which signals readiness during the grace period",
+		func(s string) (err error) {
+			readyPattern, err = regexp.Compile(s)
+			return err
+		},
+	)
+	flag.BoolVar(
+		&readyMode,
+		"ready",
+		false,
+		"Send a ready signal and then quit",
+	)
+	flag.StringVar(
+		&readySigName,
+		"ready-signal",
+		"",
+		"Which signal the ready signal uses: USR1 or USR2 (default USR1, or $"+readySignalEnv+")",
+	)
+	flag.BoolVar(
+		&recursive,
+		"recursive",
+		false,
+		"Include subdirectories of directories in -watch-path",
+	)
+	flag.DurationVar(
+		&settle,
+		"settle",
+		0,
+		"How long to wait for more changes before stopping a running command",
+	)
+	flag.DurationVar(
+		&stableFor,
+		"stable-for",
+		0,
+		"How long targets must stay up to date before leaving the grace period (before running -ready-cmd, if set)",
+	)
+	flag.StringVar(
+		&targetFile,
+		"target-file",
+		"",
+		"File with targets to build, one per line, in addition to the command line",
+	)
+	flag.BoolVar(
+		&verbose,
+		"verbose",
+		false,
+		"Log each check for changes and what it found",
+	)
+	flag.BoolVar(
+		&versionMode,
+		"version",
+		false,
+		"Display the version and then quit",
+	)
+	flag.BoolVar(
+		&watchDotfiles,
+		"watch-dotfiles",
+		false,
+		"Include dotfiles in directories from -watch-path, except .git, .hg and .svn",
+	)
+	flag.Var(
+		&watchPaths,
+		"watch-path",
+		"Extra file, directory or glob to watch for changes (repeatable)",
+	)
+
+	// Everything after "--" gets passed along to the make command.
+	args := os.Args[1:]
+	for i, arg := range args {
+		if arg == "--" {
+			args, makeArgs = args[:i], args[i+1:]
+			break
+		}
+	}
+	if err := applyEnv(flag.CommandLine); err != nil {
+		fmt.Fprintf(os.Stderr, "%s.\n", err)
+		os.Exit(1)
+	}
+	if err := flag.CommandLine.Parse(args); err != nil {
+		os.Exit(2)
+	}
+
+	// Options from the command line and environment variables
+	// take precedence over the config file.
+	if cfg, err := readConfig(configFile); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s.\n", configFile, err)
+		os.Exit(1)
+	} else if cfg != nil {
+		if err := cfg.apply(flag.CommandLine); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s.\n", configFile, err)
+			os.Exit(1)
+		}
+	}
+
+	if checkInterval <= 0 {
+		fmt.Fprintln(os.Stderr, "-check must be non-zero.")
+		os.Exit(1)
+	}
+
+	// Make commands get the ready signal in an environment variable,
+	// so that "remake -ready" sends the same one without the option.
+	if len(readySigName) == 0 {
+		readySigName = os.Getenv(readySignalEnv)
+	}
+	if len(readySigName) == 0 {
+		readySigName = "USR1"
+	}
+	if sig, err := parseReadySignal(readySigName); err != nil {
+		fmt.Fprintf(os.Stderr, "-ready-signal: %s.\n", err)
+		os.Exit(1)
+	} else {
+		readySignal = sig
+	}
+
+	if versionMode || readyMode {
+		return nil
+	}
+
+	if noColor {
+		colors.Enabled = false
+	}
+
+	if quiet && verbose {
+		fmt.Fprintln(os.Stderr, "-quiet cannot be used with -verbose.")
+		os.Exit(1)
+	}
+	logs.SetVerbose(verbose)
+
+	switch logs.Format(logFormat) {
+	case logs.Text, logs.JSON:
+		logs.SetFormat(logs.Format(logFormat))
+	default:
+		fmt.Fprintln(os.Stderr, "-log-format must be text or json.")
+		os.Exit(1)
+	}
+
+	if killTimeout < 0 {
+		fmt.Fprintln(os.Stderr, "-kill-timeout must not be negative.")
+		os.Exit(1)
+	}
+	makecmd.KillTimeout = killTimeout
+
+	if parallel < 0 {
+		fmt.Fprintln(os.Stderr, "-parallel must not be negative.")
+		os.Exit(1)
+	}
+	makecmd.Parallel = parallel
+
+	if maxRestarts < 0 {
+		fmt.Fprintln(os.Stderr, "-max-restarts must not be negative.")
+		os.Exit(1)
+	}
+
+	if once && detectOnly {
+		fmt.Fprintln(os.Stderr, "-once cannot be used with -detect-only.")
+		os.Exit(1)
+	}
+
+	if dryRun && (once || detectOnly) {
+		fmt.Fprintln(os.Stderr, "-dry-run cannot be used with -once or -detect-only.")
+		os.Exit(1)
+	}
+
+	if listMode && (once || detectOnly || dryRun) {
+		fmt.Fprintln(os.Stderr, "-list cannot be used with -once, -detect-only or -dry-run.")
+		os.Exit(1)
+	}
+
+	if checkInterval < frequentCheck {
+		// Each check runs the make query, which expands any $(shell ...)
+		// functions in the Makefile. That can be expensive or have side
+		// effects, so let the user know if it will happen a lot.
+		logs.Warnf("", "-check=%s runs the make query very frequently, including any $(shell ...) functions in the Makefile", checkInterval)
+	}
+
+	if len(historyPath) != 0 {
+		if err := checkHistory(historyPath); err != nil {
+			fmt.Fprintf(os.Stderr, "-history file cannot be written: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if _, err := exec.LookPath(makeName); err != nil {
+		fmt.Fprintf(os.Stderr, "-make executable %q not found: %s\n", makeName, err)
+		os.Exit(1)
+	}
+	makeCaps = makecmd.DetectCapabilities(makeName)
+	if makeCaps.Flavor != makedb.GNU {
+		logs.Warnf("", "%s looks like BSD make, which is not supported yet, only GNU Make is", makeName)
+	}
+
+	for _, pattern := range excludeTargets {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			fmt.Fprintf(os.Stderr, "-exclude-target pattern %q is invalid: %s\n", pattern, err)
+			os.Exit(1)
+		}
+	}
+
+	switch makecmd.Baseline(mtimeBaseline) {
+	case makecmd.ClockBaseline, makecmd.NewestPrerequisiteBaseline:
+	default:
+		fmt.Fprintln(os.Stderr, "-mtime-baseline must be clock or newest-prereq.")
+		os.Exit(1)
+	}
+
+	switch outputSync {
+	case "", "none", "line", "target", "recurse":
+	default:
+		fmt.Fprintln(os.Stderr, "-output-sync must be none, line, target or recurse.")
+		os.Exit(1)
+	}
+	if len(outputSync) != 0 && !makeCaps.OutputSync {
+		logs.Warnf("", "-output-sync is ignored because %s does not support it", makeName)
+	}
+
+	goals, dirs, err := parseGoals(flag.Args(), dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s.\n", err)
+		os.Exit(1)
+	}
+	goalDirs = dirs
+
+	if len(targetFile) != 0 {
+		targets, err := readTargetFile(targetFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "-target-file: %s.\n", err)
+			os.Exit(1)
+		}
+		goals = addGoals(goals, dir, targets)
+	}
+
+	// Handle when there are no targets in the command line arguments.
+	// Remake is consistent with Make in that it will use the default
+	// target when no target is specified.
+	if len(goals) == 0 {
+		if noDefault {
+			fmt.Fprintln(os.Stderr, "No target specified and -no-default is set.")
+			os.Exit(1)
+		}
+		goals = append(goals, goal{dir: dir})
+	}
+
+	for _, g := range goals {
+		if len(g.dir) != 0 {
+			if info, err := os.Stat(g.dir); err != nil || !info.IsDir() {
+				fmt.Fprintf(os.Stderr, "-C directory %q not found.\n", g.dir)
+				os.Exit(1)
+			}
+		}
+	}
+
+	return goals
+}
@@ -1,17 +1,44 @@
 package colors
 
+import "os"
+
 const (
+	green  = "\033[0;32m"
 	red    = "\033[0;31m"
 	yellow = "\033[0;33m"
 	reset  = "\033[0m"
 )
 
+// Enabled controls whether colors are used. By default, they are used when
+// Remake's log output (stderr) is a terminal and NO_COLOR is not set.
+var Enabled = defaultEnabled()
+
+func defaultEnabled() bool {
+	if _, found := os.LookupEnv("NO_COLOR"); found {
+		return false
+	}
+	info, err := os.Stderr.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Green adds terminal codes make text appear green.
+func Green(s string) string {
+	return color(green, s)
+}
+
 // Red adds terminal codes make text appear red.
 func Red(s string) string {
-	return red + s + reset
+	return color(red, s)
 }
 
 // Yellow adds terminal codes make text appear yellow.
 func Yellow(s string) string {
-	return yellow + s + reset
+	return color(yellow, s)
+}
+
+func color(code string, s string) string {
+	if !Enabled {
+		return s
+	}
+	return code + s + reset
 }
@@ -3,7 +3,13 @@ package colors
 import "testing"
 
 func TestColors(t *testing.T) {
-	s := Red("RED")
+	defer func(enabled bool) { Enabled = enabled }(Enabled)
+	Enabled = true
+	s := Green("GREEN")
+	if s != "\033[0;32mGREEN\033[0m" {
+		t.Errorf("Got: %s", s)
+	}
+	s = Red("RED")
 	if s != "\033[0;31mRED\033[0m" {
 		t.Errorf("Got: %s", s)
 	}
@@ -12,3 +18,24 @@ func TestColors(t *testing.T) {
 		t.Errorf("Got: %s", s)
 	}
 }
+
+func TestColorsDisabled(t *testing.T) {
+	defer func(enabled bool) { Enabled = enabled }(Enabled)
+	Enabled = false
+	if s := Green("GREEN"); s != "GREEN" {
+		t.Errorf("Got: %s", s)
+	}
+	if s := Red("RED"); s != "RED" {
+		t.Errorf("Got: %s", s)
+	}
+	if s := Yellow("YELLOW"); s != "YELLOW" {
+		t.Errorf("Got: %s", s)
+	}
+}
+
+func TestNoColor(t *testing.T) {
+	t.Setenv("NO_COLOR", "")
+	if defaultEnabled() {
+		t.Error("Expected NO_COLOR to disable colors")
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFile is the name of the project config file,
+// which is read from the current directory.
+const configFile = ".remake.yml"
+
+// config holds project defaults from the config file. Each setting is used
+// as the command line option with the same name, unless that option was
+// given on the command line.
+type config struct {
+	Check     string                `yaml:"check"`
+	Grace     string                `yaml:"grace"`
+	Ignore    []string              `yaml:"ignore"`
+	Make      string                `yaml:"make"`
+	WatchPath []string              `yaml:"watch-path"`
+	Goals     map[string]goalConfig `yaml:"goals"`
+}
+
+// goalConfig holds the settings for one target in the config file.
+type goalConfig struct {
+	Grace string `yaml:"grace"`
+}
+
+// envDefaults are environment variables that set defaults for options.
+var envDefaults = []struct {
+	env      string
+	flag     string
+	duration bool
+}{
+	{"REMAKE_CHECK", "check", true},
+	{"REMAKE_GRACE", "grace", true},
+	{"REMAKE_MAKE", "make", false},
+}
+
+// applyEnv sets flags from the envDefaults environment variables. It must
+// be used before the flags are parsed, so that the command line takes
+// precedence. They also take precedence over the config file, because
+// flags that have been set are skipped when applying it.
+func applyEnv(flags *flag.FlagSet) error {
+	for _, d := range envDefaults {
+		value := os.Getenv(d.env)
+		if len(value) == 0 {
+			continue
+		}
+		if d.duration {
+			// The flag package's error doesn't say what is wrong.
+			if _, err := time.ParseDuration(value); err != nil {
+				return fmt.Errorf("%s: %s", d.env, err)
+			}
+		}
+		if err := flags.Set(d.flag, value); err != nil {
+			return fmt.Errorf("%s: %s", d.env, err)
+		}
+	}
+	return nil
+}
+
+// readConfig reads a config file. It returns nil if the file does not exist.
+func readConfig(path string) (*config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	cfg := &config{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	// An empty file has no settings, which is fine.
+	if err := dec.Decode(cfg); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// apply sets the flags from the config, skipping those that were set
+// on the command line. It must be used after the flags have been parsed.
+func (cfg *config) apply(flags *flag.FlagSet) error {
+	given := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	set := func(name string, values ...string) error {
+		if given[name] {
+			return nil
+		}
+		for _, value := range values {
+			if len(value) == 0 {
+				continue
+			}
+			if err := flags.Set(name, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, err := range []error{
+		set("check", cfg.Check),
+		set("grace", cfg.Grace),
+		set("ignore", cfg.Ignore...),
+		set("make", cfg.Make),
+		set("watch-path", cfg.WatchPath...),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+
+	targets := make([]string, 0, len(cfg.Goals))
+	for target := range cfg.Goals {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	for _, target := range targets {
+		grace := cfg.Goals[target].Grace
+		if _, ok := graceFor[target]; ok || len(grace) == 0 {
+			// The command line takes precedence for this target.
+			continue
+		}
+		if err := flags.Set("grace-for", target+"="+grace); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig writes a config file to a temporary directory
+// and returns its path.
+func writeConfig(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "remake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, configFile)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	defer func(saved map[string]time.Duration) { graceFor = saved }(graceFor)
+	graceFor = map[string]time.Duration{}
+
+	var check, grace time.Duration
+	var ignore stringList
+	var makeName string
+	flags := flag.NewFlagSet("remake", flag.ContinueOnError)
+	flags.DurationVar(&check, "check", 2*time.Second, "")
+	flags.DurationVar(&grace, "grace", 10*time.Second, "")
+	flags.Var(&ignore, "ignore", "")
+	flags.StringVar(&makeName, "make", "make", "")
+	flags.Var(&stringList{}, "watch-path", "")
+	flags.Func("grace-for", "", setGraceFor)
+	if err := flags.Parse([]string{"-grace=5s", "-ignore=*.log", "-grace-for=api=1s"}); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := readConfig(writeConfig(t, `
+check: 1s
+grace: 30s
+ignore:
+  - "*.tmp"
+make: gmake
+goals:
+  api:
+    grace: 9s
+  web:
+    grace: 8s
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.apply(flags); err != nil {
+		t.Fatal(err)
+	}
+
+	if check != time.Second {
+		t.Errorf("Expected check from the config file, got %s", check)
+	}
+	if grace != 5*time.Second {
+		t.Errorf("Expected grace from the command line, got %s", grace)
+	}
+	if got := ignore.String(); got != "*.log" {
+		t.Errorf("Expected ignore from the command line, got %s", got)
+	}
+	if makeName != "gmake" {
+		t.Errorf("Expected make from the config file, got %s", makeName)
+	}
+	if graceFor["api"] != time.Second {
+		t.Errorf("Expected api grace from the command line, got %s", graceFor["api"])
+	}
+	if graceFor["web"] != 8*time.Second {
+		t.Errorf("Expected web grace from the config file, got %s", graceFor["web"])
+	}
+}
+
+func TestConfigMissing(t *testing.T) {
+	cfg, err := readConfig(filepath.Join(os.TempDir(), "does-not-exist", configFile))
+	if cfg != nil || err != nil {
+		t.Errorf("Expected no config and no error, got %v and %v", cfg, err)
+	}
+}
+
+func TestConfigEmpty(t *testing.T) {
+	cfg, err := readConfig(writeConfig(t, "# No settings yet.\n"))
+	if cfg == nil || err != nil {
+		t.Errorf("Expected an empty config and no error, got %v and %v", cfg, err)
+	}
+}
+
+func TestConfigMalformed(t *testing.T) {
+	for _, content := range []string{
+		"grace: [10s",
+		"grace: {after: 10s}",
+		"poll: 1s",
+	} {
+		if _, err := readConfig(writeConfig(t, content)); err == nil {
+			t.Errorf("Expected an error for %s", content)
+		}
+	}
+
+	cfg, err := readConfig(writeConfig(t, "grace: soon"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags := flag.NewFlagSet("remake", flag.ContinueOnError)
+	flags.Duration("grace", 0, "")
+	if err := cfg.apply(flags); err == nil {
+		t.Error("Expected an error for an invalid duration")
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	var check, grace time.Duration
+	var makeName string
+	newFlags := func() *flag.FlagSet {
+		flags := flag.NewFlagSet("remake", flag.ContinueOnError)
+		flags.DurationVar(&check, "check", 2*time.Second, "")
+		flags.DurationVar(&grace, "grace", 10*time.Second, "")
+		flags.StringVar(&makeName, "make", "make", "")
+		return flags
+	}
+
+	t.Setenv("REMAKE_CHECK", "1s")
+	t.Setenv("REMAKE_GRACE", "30s")
+	t.Setenv("REMAKE_MAKE", "gmake")
+
+	flags := newFlags()
+	if err := applyEnv(flags); err != nil {
+		t.Fatal(err)
+	}
+	if err := flags.Parse([]string{"-grace=5s"}); err != nil {
+		t.Fatal(err)
+	}
+	if check != time.Second {
+		t.Errorf("Expected check from the environment, got %s", check)
+	}
+	if grace != 5*time.Second {
+		t.Errorf("Expected grace from the command line, got %s", grace)
+	}
+	if makeName != "gmake" {
+		t.Errorf("Expected make from the environment, got %s", makeName)
+	}
+
+	// The environment takes precedence over the config file.
+	cfg := &config{Make: "bsdmake"}
+	if err := cfg.apply(flags); err != nil {
+		t.Fatal(err)
+	}
+	if makeName != "gmake" {
+		t.Errorf("Expected make from the environment, got %s", makeName)
+	}
+
+	t.Setenv("REMAKE_GRACE", "soon")
+	err := applyEnv(newFlags())
+	if err == nil || !strings.HasPrefix(err.Error(), "REMAKE_GRACE: ") {
+		t.Errorf("Expected an error naming REMAKE_GRACE, got %v", err)
+	}
+}
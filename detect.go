@@ -0,0 +1,29 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/raymondbutcher/remake/logs"
+)
+
+// detectEvent is a change reported by -detect-only in the JSON log format.
+type detectEvent struct {
+	Target  string   `json:"target"`
+	Pending []string `json:"pending"`
+}
+
+// detectLine returns the line that -detect-only prints when a target
+// changes. It is the target and the pending targets separated by a tab,
+// or a line of JSON with the JSON log format.
+func detectLine(name string, pending []string, format logs.Format) string {
+	if format == logs.JSON {
+		if pending == nil {
+			pending = []string{}
+		}
+		if line, err := json.Marshal(detectEvent{Target: name, Pending: pending}); err == nil {
+			return string(line)
+		}
+	}
+	return name + "\t" + strings.Join(pending, " ")
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/raymondbutcher/remake/logs"
+)
+
+func TestDetectLine(t *testing.T) {
+	for _, test := range []struct {
+		pending  []string
+		format   logs.Format
+		expected string
+	}{
+		{[]string{"app", "main.o"}, logs.Text, "app\tapp main.o"},
+		{nil, logs.Text, "app\t"},
+		{[]string{"app", "main.o"}, logs.JSON, `{"target":"app","pending":["app","main.o"]}`},
+		{nil, logs.JSON, `{"target":"app","pending":[]}`},
+	} {
+		if got := detectLine("app", test.pending, test.format); got != test.expected {
+			t.Errorf("Expected %q but got %q", test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// Package gitignore matches paths against the rules in .gitignore files.
+// It supports the common parts of the format: globs, "**", negation with
+// "!", directory-only rules ending with "/", and rules anchored with "/".
+// Rules in a nested .gitignore file only apply within its directory.
+package gitignore
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// A Matcher holds the rules from the .gitignore files in a directory tree.
+type Matcher struct {
+	rules []rule
+}
+
+type rule struct {
+	base     string // directory of the .gitignore file, relative to the root
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// Load reads the .gitignore files in a directory and its subdirectories.
+// Directories that are ignored by the rules found so far are not searched,
+// and nor is the .git directory.
+func Load(root string) (*Matcher, error) {
+	m := &Matcher{}
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel != "." && (info.Name() == ".git" || m.Match(rel, true)) {
+			return filepath.SkipDir
+		}
+		if rel == "." {
+			rel = ""
+		}
+		return m.readFile(filepath.Join(p, ".gitignore"), rel)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// readFile adds the rules from a .gitignore file, if it exists.
+func (m *Matcher) readFile(name string, base string) error {
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		m.Add(base, scanner.Text())
+	}
+	return scanner.Err()
+}
+
+// Add adds a rule from a line of a .gitignore file,
+// which is in the base directory relative to the root.
+func (m *Matcher) Add(base string, line string) {
+	line = strings.TrimRight(line, " \t\r")
+	if len(line) == 0 || strings.HasPrefix(line, "#") {
+		return
+	}
+	r := rule{base: base}
+	if strings.HasPrefix(line, "!") {
+		r.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		r.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if len(line) == 0 {
+		return
+	}
+	r.pattern = line
+	m.rules = append(m.rules, r)
+}
+
+// Match reports whether a path, relative to the root and using forward
+// slashes, is ignored. A path is also ignored when any of its parent
+// directories are ignored.
+func (m *Matcher) Match(name string, isDir bool) bool {
+	parts := strings.Split(name, "/")
+	for i := 1; i < len(parts); i++ {
+		if m.match(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return m.match(name, isDir)
+}
+
+// match checks a single path against the rules. The last matching rule wins.
+func (m *Matcher) match(name string, isDir bool) (ignored bool) {
+	for _, r := range m.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		rel := name
+		if len(r.base) != 0 {
+			if !strings.HasPrefix(name, r.base+"/") {
+				continue
+			}
+			rel = name[len(r.base)+1:]
+		}
+		var ok bool
+		if r.anchored {
+			ok = matchPath(strings.Split(r.pattern, "/"), strings.Split(rel, "/"))
+		} else {
+			ok, _ = path.Match(r.pattern, path.Base(rel))
+		}
+		if ok {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
+
+// matchPath matches path segments against pattern segments,
+// where a "**" segment matches any number of path segments.
+func matchPath(pattern []string, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchPath(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+		return false
+	}
+	return matchPath(pattern[1:], segments[1:])
+}
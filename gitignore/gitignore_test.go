@@ -0,0 +1,95 @@
+package gitignore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	m := &Matcher{}
+	for _, line := range []string{
+		"# comment",
+		"*.log",
+		"!keep.log",
+		"build/",
+		"/root.txt",
+		"docs/**/*.html",
+	} {
+		m.Add("", line)
+	}
+	m.Add("web", "dist")
+
+	for name, expected := range map[string]bool{
+		"a.log":                true,
+		"src/a.log":            true,
+		"keep.log":             false,
+		"src/keep.log":         false,
+		"build/app":            true,
+		"src/build/app":        true,
+		"root.txt":             true,
+		"src/root.txt":         false,
+		"docs/index.html":      true,
+		"docs/api/v1/ref.html": true,
+		"docs/index.md":        false,
+		"web/dist/app.js":      true,
+		"dist/app.js":          false,
+		"main.go":              false,
+	} {
+		if got := m.Match(name, false); got != expected {
+			t.Errorf("%s: expected %t got %t", name, expected, got)
+		}
+	}
+
+	// Directory-only rules don't match files.
+	m = &Matcher{}
+	m.Add("", "out/")
+	if m.Match("out", false) {
+		t.Error("Expected out file not to match")
+	}
+	if !m.Match("out", true) {
+		t.Error("Expected out directory to match")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	root := t.TempDir()
+	write := func(name string, content string) {
+		t.Helper()
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(".gitignore", "*.tmp\nnode_modules/\n")
+	write("sub/.gitignore", "gen\n!keep.tmp\n")
+	write("node_modules/pkg/.gitignore", "*.go\n")
+
+	m, err := Load(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]bool{
+		"a.tmp":          true,
+		"sub/keep.tmp":   false,
+		"sub/gen":        true,
+		"gen":            false,
+		"node_modules/x": true,
+		"main.go":        false,
+	} {
+		if got := m.Match(name, false); got != expected {
+			t.Errorf("%s: expected %t got %t", name, expected, got)
+		}
+	}
+
+	// The ignored node_modules directory was not searched.
+	for _, r := range m.rules {
+		if r.base == "node_modules/pkg" {
+			t.Error("Expected node_modules not to be searched")
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/raymondbutcher/remake/runner"
+)
+
+// A goal is a target for Remake to manage, and the directory to run make in.
+type goal struct {
+	target string
+	dir    string
+}
+
+// runnerGoal returns the goal for the runner package.
+func (g goal) runnerGoal() runner.Goal {
+	return runner.Goal{Target: g.target, Dir: g.dir}
+}
+
+// fromRunnerGoal returns the goal for a goal from the runner package.
+func fromRunnerGoal(g runner.Goal) goal {
+	return goal{target: g.Target, dir: g.Dir}
+}
+
+// runnerGoals returns the goals for the runner package.
+func runnerGoals(goals []goal) []runner.Goal {
+	result := make([]runner.Goal, len(goals))
+	for i, g := range goals {
+		result[i] = g.runnerGoal()
+	}
+	return result
+}
+
+// goalDirs is set when the -C option is used between goals,
+// so that goal names should include their directories.
+var goalDirs bool
+
+// String returns the goal's name for display purposes.
+func (g goal) String() string {
+	name := g.target
+	if len(name) == 0 {
+		name = "default goal"
+	}
+	if goalDirs && len(g.dir) != 0 {
+		name = fmt.Sprintf("%s (in %s)", name, g.dir)
+	}
+	return name
+}
+
+// parseGoals returns the goals from the command line arguments that come
+// after the options. The -C option can be used between goals to change the
+// directory for the goals after it, e.g. "remake -C a build -C b run".
+func parseGoals(args []string, dir string) (goals []goal, dirs bool, err error) {
+	for i := 0; i < len(args); i++ {
+		parts := strings.SplitN(strings.TrimLeft(args[i], "-"), "=", 2)
+		if !strings.HasPrefix(args[i], "-") || (parts[0] != "C" && parts[0] != "dir") {
+			goals = append(goals, goal{target: args[i], dir: dir})
+			continue
+		}
+		if len(parts) == 1 {
+			if i+1 == len(args) {
+				return nil, false, fmt.Errorf("-%s needs a directory", parts[0])
+			}
+			i++
+			parts = append(parts, args[i])
+		}
+		if i+1 == len(args) {
+			return nil, false, fmt.Errorf("-%s %s must be followed by a target", parts[0], parts[1])
+		}
+		dir = parts[1]
+		dirs = true
+	}
+	return goals, dirs, nil
+}
+
+// readTargetFile returns the targets in a file, which has one target per
+// line. Everything after a "#" is a comment, and empty lines are skipped.
+func readTargetFile(path string) (targets []string, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.IndexByte(line, '#'); i != -1 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); len(line) != 0 {
+			targets = append(targets, line)
+		}
+	}
+	return targets, nil
+}
+
+// addGoals adds goals for the targets in a directory,
+// skipping any that are already in the goals.
+func addGoals(goals []goal, dir string, targets []string) []goal {
+	seen := map[goal]bool{}
+	for _, g := range goals {
+		seen[g] = true
+	}
+	for _, target := range targets {
+		g := goal{target: target, dir: dir}
+		if !seen[g] {
+			seen[g] = true
+			goals = append(goals, g)
+		}
+	}
+	return goals
+}
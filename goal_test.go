@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseGoals(t *testing.T) {
+	args := []string{"build", "-C", "backend", "run", "test", "-dir=frontend", "build"}
+	goals, dirs, err := parseGoals(args, "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dirs {
+		t.Error("Expected goal directories")
+	}
+	expected := "build:root run:backend test:backend build:frontend "
+	got := ""
+	for _, g := range goals {
+		got += fmt.Sprintf("%s:%s ", g.target, g.dir)
+	}
+	if got != expected {
+		t.Errorf("Expected %s but got %s", expected, got)
+	}
+
+	goals, dirs, err = parseGoals([]string{"build"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dirs || len(goals) != 1 || goals[0].String() != "build" {
+		t.Errorf("Unexpected goals %v", goals)
+	}
+
+	for _, args := range [][]string{{"build", "-C"}, {"build", "-C", "backend"}} {
+		if _, _, err := parseGoals(args, ""); err == nil {
+			t.Errorf("Expected an error for %q", args)
+		}
+	}
+}
+
+func TestReadTargetFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "targets")
+	content := "# Servers\n  server  \n\nworker # background jobs\n\t\nbuild\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	targets, err := readTargetFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(targets, ","); got != "server,worker,build" {
+		t.Errorf("Expected server,worker,build but got %s", got)
+	}
+
+	// Targets from the file are added after the command line goals.
+	goals := addGoals([]goal{{target: "build"}, {target: "lint"}}, "", targets)
+	got := ""
+	for _, g := range goals {
+		got += g.target + " "
+	}
+	if got != "build lint server worker " {
+		t.Errorf("Unexpected goals %q", got)
+	}
+
+	if _, err := readTargetFile(filepath.Join(dir, "missing")); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
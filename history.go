@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/raymondbutcher/remake/logs"
+)
+
+// historyMaxSize is the size at which the history file gets rotated.
+// The previous file is kept with a ".1" suffix.
+var historyMaxSize int64 = 10 * 1024 * 1024
+
+// historyRecord is written to the history file after each build.
+type historyRecord struct {
+	Time     time.Time `json:"time"`
+	Goal     string    `json:"goal"`
+	Trigger  string    `json:"trigger"`
+	Result   string    `json:"result"`
+	Error    string    `json:"error,omitempty"`
+	Duration float64   `json:"duration"`
+}
+
+var historyMutex sync.Mutex
+
+// checkHistory checks that the history file can be written to.
+func checkHistory(path string) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// writeHistory appends a record of a build to the -history file,
+// if one has been specified.
+func writeHistory(g goal, trigger string, duration time.Duration, err error) {
+	if len(historyPath) == 0 {
+		return
+	}
+	record := historyRecord{
+		Time:     time.Now(),
+		Goal:     g.String(),
+		Trigger:  trigger,
+		Result:   "ok",
+		Duration: duration.Seconds(),
+	}
+	if err != nil {
+		record.Result = "failed"
+		record.Error = err.Error()
+	}
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	if err := appendHistory(historyPath, record); err != nil {
+		logs.Errorf(g.target, "Error writing history: %s", err)
+	}
+}
+
+// appendHistory writes a record to the end of a file,
+// rotating the file first if it has become too big.
+func appendHistory(path string, record historyRecord) error {
+	if info, err := os.Stat(path); err == nil && info.Size() >= historyMaxSize {
+		if err := os.Rename(path, path+".1"); err != nil {
+			return err
+		}
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readHistory returns the lines in a history file.
+func readHistory(t *testing.T, path string) []string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(string(bytes.TrimSuffix(data, []byte("\n"))), "\n")
+}
+
+func TestWriteHistory(t *testing.T) {
+	defer func(saved string) { historyPath = saved }(historyPath)
+	historyPath = filepath.Join(t.TempDir(), "history.jsonl")
+
+	start := time.Now()
+	writeHistory(goal{target: "app"}, "start", 1500*time.Millisecond, nil)
+	writeHistory(goal{}, "change", time.Second, errors.New("exit status 2"))
+
+	lines := readHistory(t, historyPath)
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines but got %d", len(lines))
+	}
+	records := make([]historyRecord, len(lines))
+	for i, line := range lines {
+		if err := json.Unmarshal([]byte(line), &records[i]); err != nil {
+			t.Fatalf("Expected a JSON record but got %s: %s", line, err)
+		}
+	}
+	if r := records[0]; r.Goal != "app" || r.Trigger != "start" || r.Result != "ok" || r.Error != "" || r.Duration != 1.5 {
+		t.Errorf("Expected a successful build of app but got %+v", r)
+	}
+	if r := records[1]; r.Goal != "default goal" || r.Trigger != "change" || r.Result != "failed" || r.Error != "exit status 2" {
+		t.Errorf("Expected a failed build of the default goal but got %+v", r)
+	}
+
+	// Times are written in RFC 3339 format, so that other tools can read them.
+	var raw struct{ Time string }
+	if err := json.Unmarshal([]byte(lines[0]), &raw); err != nil {
+		t.Fatal(err)
+	}
+	written, err := time.Parse(time.RFC3339Nano, raw.Time)
+	if err != nil {
+		t.Errorf("Expected an RFC 3339 time but got %s", raw.Time)
+	} else if written.Before(start.Truncate(time.Second)) || written.After(time.Now()) {
+		t.Errorf("Expected the time of the build but got %s", written)
+	}
+}
+
+func TestAppendHistoryRotation(t *testing.T) {
+	defer func(saved int64) { historyMaxSize = saved }(historyMaxSize)
+	historyMaxSize = 300
+
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	write := func(n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			record := historyRecord{Time: time.Now(), Goal: strings.Repeat("x", 100), Trigger: "change", Result: "ok"}
+			if err := appendHistory(path, record); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Each record is about 200 bytes, so the file is rotated
+	// when the third one is written.
+	write(3)
+	if got := len(readHistory(t, path+".1")); got != 2 {
+		t.Errorf("Expected 2 records in the rotated file but got %d", got)
+	}
+	if got := len(readHistory(t, path)); got != 1 {
+		t.Errorf("Expected 1 record in the new file but got %d", got)
+	}
+
+	// Rotating again replaces the previous rotated file.
+	write(2)
+	if got := len(readHistory(t, path+".1")); got != 2 {
+		t.Errorf("Expected 2 records in the rotated file but got %d", got)
+	}
+	if got := len(readHistory(t, path)); got != 1 {
+		t.Errorf("Expected 1 record in the new file but got %d", got)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/raymondbutcher/remake/logs"
+	"github.com/raymondbutcher/remake/makecmd"
+)
+
+var (
+	hooks        = map[goal]*makecmd.CmdProcess{}
+	hooksMutex   sync.Mutex
+	hooksStopped bool
+)
+
+// runHook runs the -on-success or -on-failure command after a goal's make
+// command has finished, depending on the result. The name of the goal's
+// target is in the REMAKE_TARGET environment variable. Any hook that is
+// still running for the goal is killed first.
+func runHook(g goal, err error) {
+	command := onSuccess
+	if err != nil {
+		command = onFailure
+	}
+	if len(command) == 0 {
+		return
+	}
+
+	hooksMutex.Lock()
+	defer hooksMutex.Unlock()
+	if hooksStopped {
+		return
+	}
+	killHook(g)
+
+	hook := makecmd.NewCmdProcess("sh", "-c", command)
+	hook.AddEnv("REMAKE_TARGET=" + g.target)
+	if err := hook.Start(); err != nil {
+		logs.Errorf(g.target, "Error running hook for %s: %s", g, err)
+		return
+	}
+	hooks[g] = hook
+	go func() {
+		err := <-hook.Finished()
+		hooksMutex.Lock()
+		defer hooksMutex.Unlock()
+		if err != nil && hooks[g] == hook {
+			// It failed by itself, rather than being killed.
+			logs.Errorf(g.target, "Hook for %s failed: %s", g, err)
+		}
+	}()
+}
+
+// stopHook kills the goal's hook if it is still running,
+// so that it doesn't overlap with the next build.
+func stopHook(g goal) {
+	hooksMutex.Lock()
+	defer hooksMutex.Unlock()
+	killHook(g)
+}
+
+// stopHooks kills any hooks that are still running,
+// and stops any more from running, when Remake is shutting down.
+func stopHooks() {
+	hooksMutex.Lock()
+	defer hooksMutex.Unlock()
+	hooksStopped = true
+	for g := range hooks {
+		killHook(g)
+	}
+}
+
+func killHook(g goal) {
+	if hook := hooks[g]; hook != nil {
+		if err := hook.Kill(); err != nil {
+			logs.Errorf(g.target, "Error killing hook for %s: %s", g, err)
+		}
+		delete(hooks, g)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/raymondbutcher/remake/makecmd"
+)
+
+// listTargets prints the targets in a goal's make database for -list mode,
+// with their status and prerequisites, starting with the default goal.
+// Files that are not targets, and special targets such as .PHONY and
+// suffix rules, are left out.
+func listTargets(g goal) error {
+	cmd := makecmd.NewCmd(g.target, goalOptions(g, ""))
+	db, err := cmd.Database()
+	if err != nil {
+		return err
+	}
+	for _, name := range db.SortedTargetNames() {
+		t := db.Targets[name]
+		if t.NotTarget || strings.HasPrefix(name, ".") {
+			continue
+		}
+		line := t.String()
+		if len(t.NormalPrerequisites) != 0 || len(t.OrderOnlyPrerequisites) != 0 {
+			line += ": " + strings.Join(t.NormalPrerequisites, " ")
+		}
+		if len(t.OrderOnlyPrerequisites) != 0 {
+			line += " | " + strings.Join(t.OrderOnlyPrerequisites, " ")
+		}
+		fmt.Println(strings.TrimSpace(line))
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+// Package logs writes Remake's log messages, either as colored text
+// or as JSON lines for supervisors and CI systems to read.
+package logs
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/raymondbutcher/remake/colors"
+)
+
+// Format determines how log messages are written.
+type Format string
+
+const (
+	// Text writes messages using the standard logger, with colors.
+	Text Format = "text"
+
+	// JSON writes each message as a line of JSON, without colors.
+	JSON Format = "json"
+)
+
+var (
+	format  = Text
+	verbose bool
+)
+
+// SetFormat sets the format of log messages. The default is Text.
+func SetFormat(f Format) {
+	format = f
+}
+
+// SetVerbose enables or disables debug messages. They are disabled by default.
+func SetVerbose(v bool) {
+	verbose = v
+}
+
+// entry is a log message in the JSON format.
+type entry struct {
+	Time   string `json:"time"`
+	Level  string `json:"level"`
+	Target string `json:"target,omitempty"`
+	Msg    string `json:"msg"`
+}
+
+// Errorf logs an error, which is red in the Text format.
+// The target is the goal that the message relates to, if any.
+func Errorf(target string, f string, v ...interface{}) {
+	write("error", colors.Red, target, fmt.Sprintf(f, v...))
+}
+
+// Warnf logs a warning, which is yellow in the Text format.
+func Warnf(target string, f string, v ...interface{}) {
+	write("warn", colors.Yellow, target, fmt.Sprintf(f, v...))
+}
+
+// Successf logs a success message, which is green in the Text format.
+// It has the info level in the JSON format.
+func Successf(target string, f string, v ...interface{}) {
+	write("info", colors.Green, target, fmt.Sprintf(f, v...))
+}
+
+// Infof logs an informational message.
+func Infof(target string, f string, v ...interface{}) {
+	write("info", nil, target, fmt.Sprintf(f, v...))
+}
+
+// Debugf logs a debug message, if they have been enabled with SetVerbose.
+func Debugf(target string, f string, v ...interface{}) {
+	if verbose {
+		write("debug", nil, target, fmt.Sprintf(f, v...))
+	}
+}
+
+func write(level string, color func(string) string, target string, msg string) {
+	if format == JSON {
+		line, err := json.Marshal(entry{
+			Time:   time.Now().Format(time.RFC3339Nano),
+			Level:  level,
+			Target: target,
+			Msg:    msg,
+		})
+		if err == nil {
+			_, err = log.Writer().Write(append(line, '\n'))
+		}
+		if err == nil {
+			return
+		}
+		// Fall back to the Text format rather than losing the message.
+	}
+	msg = "Remake: " + msg
+	if color != nil {
+		msg = color(msg)
+	}
+	log.Print(msg)
+}
@@ -0,0 +1,59 @@
+package logs
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/raymondbutcher/remake/colors"
+)
+
+func TestText(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	defer log.SetOutput(os.Stderr)
+	defer log.SetFlags(log.LstdFlags)
+	defer func(enabled bool) { colors.Enabled = enabled }(colors.Enabled)
+	colors.Enabled = true
+
+	Errorf("t1", "failed: %s", "oops")
+	if got := buf.String(); got != "\033[0;31mRemake: failed: oops\033[0m\n" {
+		t.Errorf("Got: %q", got)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	SetFormat(JSON)
+	defer SetFormat(Text)
+
+	Warnf("t1", "waiting for %s", "t2")
+	var e entry
+	if err := json.Unmarshal(buf.Bytes(), &e); err != nil {
+		t.Fatalf("Invalid JSON %q: %s", buf.String(), err)
+	}
+	if e.Level != "warn" || e.Target != "t1" || e.Msg != "waiting for t2" || e.Time == "" {
+		t.Errorf("Got: %+v", e)
+	}
+}
+
+func TestDebug(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	defer log.SetOutput(os.Stderr)
+	defer log.SetFlags(log.LstdFlags)
+
+	Debugf("t1", "hidden")
+	SetVerbose(true)
+	defer SetVerbose(false)
+	Debugf("t1", "checking %s", "t1")
+	if got := buf.String(); got != "Remake: checking t1\n" {
+		t.Errorf("Got: %q", got)
+	}
+}
@@ -1,169 +1,216 @@
 package main
 
 import (
-	"flag"
 	"fmt"
-	"log"
+	"io/ioutil"
 	"os"
-	"time"
+	"path/filepath"
+	"strconv"
 
-	"github.com/raymondbutcher/remake/colors"
+	"github.com/raymondbutcher/remake/logs"
 	"github.com/raymondbutcher/remake/makecmd"
+	"github.com/raymondbutcher/remake/runner"
 )
 
-const (
-	errorSleep = 5 * time.Second
-	version    = "0.1.0"
-)
-
-var (
-	checkInterval time.Duration
-	gracePeriod   time.Duration
-	readyMode     bool
-	versionMode   bool
-)
+const version = "0.1.0"
 
 func main() {
 
-	flag.DurationVar(
-		&checkInterval,
-		"check",
-		2*time.Second,
-		"Interval between checking for changes",
-	)
-	flag.DurationVar(
-		&gracePeriod,
-		"grace",
-		10*time.Second,
-		"Grace period for commands to finish building",
-	)
-	flag.BoolVar(
-		&readyMode,
-		"ready",
-		false,
-		"Send a ready signal and then quit",
-	)
-	flag.BoolVar(
-		&versionMode,
-		"version",
-		false,
-		"Display the version and then quit",
-	)
-
-	flag.Parse()
-
-	if checkInterval <= 0 {
-		fmt.Fprintln(os.Stderr, "-check must be non-zero.")
-		os.Exit(1)
-	}
+	goals := processArguments()
 
 	if versionMode {
 		fmt.Println(version)
 		os.Exit(0)
 	}
 
-	// Handle when there are no targets in the command line arguments.
-	// Remake is consistent with Make in that it will use the default
-	// target when no target is specified.
-	goals := flag.Args()
-	if len(goals) == 0 {
-		goals = append(goals, "")
-	}
-
 	// If "remake -ready" was run, send the ready signal and then exit.
 	if readyMode {
-		err := SendReadySignal()
+		err := SendReadySignal(readySignal)
 		if err != nil {
-			fmt.Fprint(os.Stderr, err)
+			fmt.Fprintf(os.Stderr, "Error sending the ready signal: %s\n", err)
 			os.Exit(1)
 		}
 		os.Exit(0)
 	}
 
-	// Handle signals received from "remake -ready".
-	ready := makeReadyChannel(goals)
-
-	// Start managing each goal as a separate goroutine.
-	for _, goal := range goals {
-		go remake(goal, ready)
+	// If "remake -list" was run, print the targets and then exit.
+	if listMode {
+		for i, g := range goals {
+			if len(goals) > 1 {
+				if i != 0 {
+					fmt.Println()
+				}
+				fmt.Printf("# %s\n", g)
+			}
+			if err := listTargets(g); err != nil {
+				logs.Errorf(g.target, "%s", err)
+				os.Exit(1)
+			}
+		}
+		os.Exit(0)
 	}
 
-	// Block execution forever and let the goroutines work.
-	<-make(<-chan struct{})
-}
+	// Handle signals received from "remake -ready".
+	ready := makeReadyChannels(goals)
 
-// remake runs the main loop for one make command. It never returns.
-func remake(target string, ready <-chan bool) {
-	var cmd *makecmd.Cmd
-	check, _ := makeCheckChannel()
-	for {
-		// Create the make command for this target.
-		cmd = makecmd.NewCmd(target)
-
-		// Start the command in grace mode. It won't return until
-		// it leaves grace mode and it is time for monitoring.
-		if err := cmd.StartGraceMode(gracePeriod, ready, check); err != nil {
-			log.Printf(colors.Red("Remake: %s"), err)
-			time.Sleep(errorSleep)
-		} else {
-			// And now monitor for changes. It won't return
-			// until the make command needs to be restarted.
-			cmd.MonitorMode(check)
+	if len(httpAddr) != 0 {
+		if err := startHTTP(httpAddr, goals); err != nil {
+			fmt.Fprintf(os.Stderr, "-http: %s.\n", err)
+			os.Exit(1)
 		}
+	}
 
+	// Manage the goals until Remake is terminated,
+	// or until they have all finished with the -once option.
+	stop := make(chan struct{})
+	done := make(chan []runner.Result)
+	go func() {
+		done <- runner.RunGoals(runnerConfig(ready, stop), runnerGoals(goals))
+	}()
+	var results []runner.Result
+	select {
+	case <-makeTerminateChannel():
+		close(stop)
+		results = <-done
+	case results = <-done:
 	}
+	os.Exit(shutdown(results))
 }
 
-// makeCheckChannel returns a channel that is populated when Remake should
-// check for changes. Its behavior depends on the -check option.
-func makeCheckChannel() (ch chan struct{}, stop func()) {
+// runnerConfig returns the settings for managing the goals,
+// based on the command line options.
+func runnerConfig(ready map[goal]chan bool, stop <-chan struct{}) runner.Config {
+	mode := runner.Watch
+	if detectOnly {
+		mode = runner.DetectOnly
+	} else if dryRun {
+		mode = runner.DryRun
+	} else if once {
+		mode = runner.Once
+	}
+	readyChannels := map[runner.Goal]<-chan bool{}
+	for g, ch := range ready {
+		readyChannels[g.runnerGoal()] = ch
+	}
+	return runner.Config{
+		Mode: mode,
+		GoalOptions: func(g runner.Goal, trigger string) makecmd.Options {
+			return goalOptions(fromRunnerGoal(g), trigger)
+		},
+		CheckInterval: checkInterval,
+		GracePeriod:   gracePeriod,
+		GraceFor:      graceFor,
+		MaxRestarts:   maxRestarts,
+		Coalesce:      coalesceBuilds,
+		Ready:         readyChannels,
+		Stop:          stop,
+		OnCommand: func(g runner.Goal, cmd *makecmd.Cmd) {
+			stopHook(fromRunnerGoal(g))
+		},
+		OnRestart: func(g runner.Goal) {
+			countRestart(fromRunnerGoal(g))
+		},
+		OnDetect: func(g runner.Goal, name string, pending []string) {
+			fmt.Println(detectLine(name, pending, logs.Format(logFormat)))
+		},
+	}
+}
 
-	ch = make(chan struct{})
+// goalEnv returns the environment variables for a goal's make command.
+// They tell "remake -ready" how to send the ready signal.
+func goalEnv(g goal) []string {
+	env := []string{
+		readySignalEnv + "=" + readySigName,
+		goalEnvName + "=" + g.target,
+	}
+	if file, found := readyFiles[g]; found {
+		env = append(env, readyFileEnv+"="+file)
+	}
+	return env
+}
 
-	var checkch <-chan time.Time
-	checkch = time.After(checkInterval)
+// goalOptions returns the make command options for a goal, based on the
+// command line options. The trigger is the reason for running the command.
+func goalOptions(g goal, trigger string) makecmd.Options {
+	return makecmd.Options{
+		Make:          makeName,
+		Capabilities:  &makeCaps,
+		Args:          makeArgs,
+		Env:           goalEnv(g),
+		Dir:           g.dir,
+		OutputSync:    outputSync,
+		Prefix:        goalPrefix(g),
+		Quiet:         quiet,
+		CacheDatabase: cacheDatabase,
+		FinishCurrent: finishCurrent.Contains(g.target),
+		StableFor:     stableFor,
+		Settle:        settle,
+		GraphChanges:  graphChanges,
+		ReadyCmd:      readyCmd,
+		ReadyPattern:  readyPattern,
+		WatchPaths:    watchPaths,
+		Exclude:       excludeTargets,
+		Ignore:        ignore,
+		GitIgnore:     gitIgnore,
+		Recursive:     recursive,
+		WatchDotfiles: watchDotfiles,
+		MtimeBaseline: makecmd.Baseline(mtimeBaseline),
+		OnFinish: func(cmd *makecmd.Cmd, err error) {
+			recordBuild(g, cmd.Duration(), err)
+			writeHistory(g, trigger, cmd.Duration(), err)
+			runHook(g, err)
+		},
+		OnCheck: func(cmd *makecmd.Cmd) {
+			recordCheck(g, cmd)
+		},
+	}
+}
 
-	stopch := make(chan struct{})
+// goalPrefix returns the prefix for lines of a goal's make output,
+// or an empty string when the -prefix option is not used.
+func goalPrefix(g goal) string {
+	if !prefix {
+		return ""
+	}
+	return fmt.Sprintf("[%s] ", g)
+}
 
+// makeReadyChannels returns a channel for each goal, for receiving the
+// ready signal. With multiple goals, the make commands are given a file to
+// create before sending the ready signal, which tells Remake which goal
+// the signal is for.
+func makeReadyChannels(goals []goal) map[goal]chan bool {
+	channels := map[goal]chan bool{}
+	for _, g := range goals {
+		channels[g] = make(chan bool)
+	}
+	if len(goals) > 1 {
+		dir, err := ioutil.TempDir("", "remake-ready-")
+		if err != nil {
+			logs.Warnf("", "The ready signal will not work: %s", err)
+			return channels
+		}
+		readyDir = dir
+		for i, g := range goals {
+			readyFiles[g] = filepath.Join(dir, strconv.Itoa(i))
+		}
+	}
 	go func() {
+		sigchan := ReceiveReadySignal(readySignal)
 		for {
-			select {
-			case <-checkch:
-				ch <- struct{}{}
-				checkch = time.After(checkInterval)
-			case <-stopch:
-				close(ch)
-				close(stopch)
-				return
+			<-sigchan
+			if len(goals) == 1 {
+				channels[goals[0]] <- true
+				continue
+			}
+			for _, g := range goals {
+				if os.Remove(readyFiles[g]) == nil {
+					go func(ch chan bool) {
+						ch <- true
+					}(channels[g])
+				}
 			}
 		}
 	}()
-
-	stop = func() {
-		stopch <- struct{}{}
-	}
-
-	return
-}
-
-// makeReadyChannel returns a channel for receiving the ready signal.
-// If there are multiple goals, then it will never receive anything,
-// as that is not supported.
-func makeReadyChannel(goals []string) <-chan bool {
-	ready := make(chan bool)
-	if len(goals) == 1 {
-		// When managing just one target, listen for the ready signal.
-		// But don't listen when there are multiple targets, because
-		// it wouldn't be known which make process the ready signal
-		// is coming from. Unix does support figuring this out,
-		// but the Go libraries don't.
-		go func() {
-			sigchan := ReceiveReadySignal()
-			for {
-				<-sigchan
-				ready <- true
-			}
-		}()
-	}
-	return ready
+	return channels
 }
@@ -2,52 +2,240 @@ package makecmd
 
 import (
 	"bytes"
-	"log"
+	"fmt"
+	"io/ioutil"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
 
-	"github.com/raymondbutcher/remake/colors"
+	"github.com/raymondbutcher/remake/gitignore"
+	"github.com/raymondbutcher/remake/logs"
 	"github.com/raymondbutcher/remake/makedb"
 )
 
 // Cmd is used to manage a make command, its running process,
 // and to check if its target is up to date.
 type Cmd struct {
-	Target      string
-	cmd         *CmdProcess
-	queryArgs   []string
-	db          *makedb.Database
-	progressed  time.Time
-	remaining   int
-	usedChanged bool
+	Target        string
+	cmd           *CmdProcess
+	opts          Options
+	queryArgs     []string
+	db            *makedb.Database
+	progressed    time.Time
+	since         time.Time
+	refreshed     time.Time
+	remaining     int
+	usedChanged   bool
+	ready         chan struct{}
+	watched       watchSnapshot
+	gitignore     *gitignore.Matcher
+	cacheKey      watchSnapshot
+	makefileTimes watchSnapshot
+}
+
+// Baseline determines what file modification times are compared against,
+// when checking if the prerequisites of phony targets have changed.
+type Baseline string
+
+const (
+	// ClockBaseline compares against the time that progress was updated.
+	ClockBaseline Baseline = "clock"
+
+	// NewestPrerequisiteBaseline compares against the newest prerequisite
+	// modification time at the time that progress was updated.
+	NewestPrerequisiteBaseline Baseline = "newest-prereq"
+)
+
+// errorSleep is how long to wait before trying again after an error.
+const errorSleep = 5 * time.Second
+
+// Options holds the per-goal settings for a make command.
+type Options struct {
+	// Make is the name or path of the make executable.
+	// The default is "make".
+	Make string
+
+	// Capabilities describe the make executable, so that only supported
+	// options are used. The default is to assume a recent GNU Make.
+	// See DetectCapabilities.
+	Capabilities *Capabilities
+
+	// Args are extra arguments for the make command. Variable assignments,
+	// and options that change how the makefiles are read (such as -f and
+	// -I), are also used when querying the make database.
+	Args []string
+
+	// Env has extra environment variables for the make command,
+	// in "key=value" form.
+	Env []string
+
+	// OutputSync is passed to make's --output-sync option when building,
+	// to group the output of parallel builds. It must be one of "none",
+	// "line", "target" or "recurse".
+	OutputSync string
+
+	// Quiet discards the standard output of the make command.
+	// Its standard error is still shown.
+	Quiet bool
+
+	// Prefix is added to the start of each line of the make command's
+	// output, so that output from different goals can be told apart.
+	Prefix string
+
+	// ExtraGoals are built by the same make command as the target, so that
+	// make can build any shared prerequisites once. Only the target is
+	// checked for progress and changes.
+	ExtraGoals []string
+
+	// MaxAge is how long the make database can be reused for, before the
+	// make query needs to run again. The default is to always run it.
+	MaxAge time.Duration
+
+	// CacheDatabase reuses the make database until any of the makefiles
+	// change, checking file modification times instead of running the make
+	// query each time. Prerequisites that are found dynamically, such as
+	// with $(wildcard ...), are not updated until the next build.
+	CacheDatabase bool
+
+	// Dir is the directory to run make in.
+	// The default is the current directory.
+	Dir string
+
+	// FinishCurrent lets a running command finish when changes are
+	// detected, instead of killing it, before it gets restarted.
+	FinishCurrent bool
+
+	// StableFor is how long the target must remain up to date during
+	// grace mode before it is considered done.
+	StableFor time.Duration
+
+	// Settle is how long to wait after detecting a change while the command
+	// is running, before stopping it. If the target is up to date by then,
+	// then the command is left alone.
+	Settle time.Duration
+
+	// Exclude contains glob patterns for target names to leave out when
+	// checking if the target is up to date, and when watching files.
+	// This is for prerequisites that always look out of date.
+	Exclude []string
+
+	// GraphChanges makes the target count as changed whenever its
+	// dependency graph changes, even if no files have changed.
+	GraphChanges bool
+
+	// ReadyCmd is a shell command that is run during grace mode to check
+	// if the target is ready. When it succeeds, grace mode is finished.
+	// If StableFor is set too, then it is only run once the target has
+	// been stable for that long.
+	ReadyCmd string
+
+	// ReadyPattern is matched against each line of the make command's
+	// output. When a line matches during grace mode, it is finished.
+	ReadyPattern *regexp.Regexp
+
+	// WatchPaths are extra files, directories or glob patterns to watch for
+	// changes, which are not prerequisites in the Makefile. Relative paths
+	// are relative to Dir.
+	WatchPaths []string
+
+	// Ignore contains glob patterns for files to skip when watching the
+	// WatchPaths. They are matched against base names and paths relative
+	// to Dir.
+	Ignore []string
+
+	// Recursive makes directories in the WatchPaths include all of their
+	// subdirectories. Symlinks to directories are followed.
+	Recursive bool
+
+	// WatchDotfiles includes files and directories starting with "." when
+	// watching the WatchPaths, except for version control directories.
+	WatchDotfiles bool
+
+	// GitIgnore skips files ignored by .gitignore files in Dir and its
+	// subdirectories when watching the WatchPaths.
+	GitIgnore bool
+
+	// MtimeBaseline determines what phony target prerequisites are
+	// compared against. The default is ClockBaseline.
+	MtimeBaseline Baseline
+
+	// OnFinish is called when the command exits by itself, rather than
+	// being killed, with the result of the command.
+	OnFinish func(cmd *Cmd, err error)
+
+	// OnCheck is called after each successful check for progress in grace
+	// mode, or for changes in monitor mode.
+	OnCheck func(cmd *Cmd)
 }
 
 // NewCmd initializes a make command.
-func NewCmd(target string) *Cmd {
-	cmdArgs := []string{
-		"--warn-undefined-variables",
+func NewCmd(target string, opts Options) *Cmd {
+	caps := gnuCapabilities
+	if opts.Capabilities != nil {
+		caps = *opts.Capabilities
 	}
-	queryArgs := []string{
-		"--warn-undefined-variables",
-		"--question",
-		"--print-data-base",
+	cmdArgs := []string{}
+	queryArgs := []string{}
+	if caps.WarnUndefined {
+		cmdArgs = append(cmdArgs, "--warn-undefined-variables")
+		queryArgs = append(queryArgs, "--warn-undefined-variables")
 	}
+	queryArgs = append(queryArgs, "--question", "--print-data-base")
+	if len(opts.OutputSync) != 0 && caps.OutputSync {
+		// Only the build needs this; the query doesn't run recipes.
+		cmdArgs = append(cmdArgs, "--output-sync="+opts.OutputSync)
+	}
+	cmdArgs = append(cmdArgs, opts.Args...)
+	queryArgs = append(queryArgs, databaseArgs(opts.Args)...)
 	if len(target) != 0 {
 		cmdArgs = append(cmdArgs, target)
 		queryArgs = append(queryArgs, target)
 	}
+	cmdArgs = append(cmdArgs, opts.ExtraGoals...)
+	if len(opts.Make) == 0 {
+		opts.Make = "make"
+	}
+	cmd := NewCmdProcess(opts.Make, cmdArgs...)
+	cmd.cmd.Dir = opts.Dir
+	if len(opts.Env) != 0 {
+		cmd.AddEnv(opts.Env...)
+	}
+	if opts.Quiet {
+		cmd.cmd.Stdout = ioutil.Discard
+	}
+	if len(opts.Prefix) != 0 {
+		cmd.cmd.Stdout = newPrefixWriter(cmd.cmd.Stdout, opts.Prefix)
+		cmd.cmd.Stderr = newPrefixWriter(cmd.cmd.Stderr, opts.Prefix)
+	}
+	var ready chan struct{}
+	if opts.ReadyPattern != nil {
+		ready = make(chan struct{}, 1)
+		cmd.cmd.Stdout = newPatternWriter(cmd.cmd.Stdout, opts.ReadyPattern, ready)
+		cmd.cmd.Stderr = newPatternWriter(cmd.cmd.Stderr, opts.ReadyPattern, ready)
+	}
 	return &Cmd{
 		Target:    target,
-		cmd:       NewCmdProcess("make", cmdArgs...),
+		cmd:       cmd,
 		queryArgs: queryArgs,
+		opts:      opts,
+		ready:     ready,
 	}
 }
 
 // GetFiles gets the filenames of the command's target and its dependencies.
-func (mc *Cmd) GetFiles() (names []string) {
+// The names are relative to the directory that make runs in. Phony targets
+// are left out because they are not files, but the prerequisites of phony
+// targets are included.
+func (mc *Cmd) GetFiles() (names []string, err error) {
 	// Use the last known database to avoid running make again.
 	if mc.db == nil {
-		mc.db = mc.getDatabase()
+		if _, err := mc.getDatabase(); err != nil {
+			return nil, err
+		}
 	}
 	add := func(t *makedb.Target) {
 		if !t.Phony {
@@ -58,12 +246,61 @@ func (mc *Cmd) GetFiles() (names []string) {
 	add(t)
 	nDeps, oDeps := mc.db.GetDeps(t.Name)
 	for _, name := range nDeps {
-		add(mc.db.GetTarget(name))
+		add(mc.db.GetPrerequisite(name))
 	}
 	for _, name := range oDeps {
-		add(mc.db.GetTarget(name))
+		add(mc.db.GetPrerequisite(name))
 	}
-	return
+	return names, nil
+}
+
+// GetWatchDirs gets the directories that contain the files from GetFiles,
+// which are where changes to those files would happen. A name that is an
+// existing directory is included as it is. For a name that does not exist
+// yet, it is the nearest existing parent directory, so that creating the
+// file is noticed. The directories are sorted and relative to the directory
+// that make runs in.
+func (mc *Cmd) GetWatchDirs() ([]string, error) {
+	names, err := mc.GetFiles()
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	dirs := []string{}
+	for _, name := range names {
+		dir := mc.watchDir(name)
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+	return dirs, nil
+}
+
+// watchDir returns the directory to watch for changes to a file.
+func (mc *Cmd) watchDir(name string) string {
+	stat := func(name string) (os.FileInfo, error) {
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(mc.dir(), name)
+		}
+		return os.Stat(name)
+	}
+	info, err := stat(name)
+	if err == nil && info.IsDir() {
+		return filepath.Clean(name)
+	}
+	dir := filepath.Dir(name)
+	if err != nil && os.IsNotExist(err) {
+		// Missing parent directories can't be watched either.
+		for dir != "." && dir != filepath.Dir(dir) {
+			if _, err := stat(dir); !os.IsNotExist(err) {
+				break
+			}
+			dir = filepath.Dir(dir)
+		}
+	}
+	return dir
 }
 
 // HasChanged checks if the make command's target has changed since Progress()
@@ -71,7 +308,7 @@ func (mc *Cmd) GetFiles() (names []string) {
 // "grace mode" to find out when the make command has finished building itself
 // and its dependencies. Afterwards, HasChanged should be used to check
 // if the command should be restarted due to new changes.
-func (mc *Cmd) HasChanged() bool {
+func (mc *Cmd) HasChanged() (bool, error) {
 
 	if mc.progressed.IsZero() {
 		panic("Cannot use HasChanged before UpdateProgress")
@@ -80,17 +317,66 @@ func (mc *Cmd) HasChanged() bool {
 		mc.usedChanged = true
 	}
 
-	return mc.getRemaining() > 0
+	prev := mc.db
+	remaining, err := mc.getRemaining()
+	if err != nil {
+		return false, err
+	}
+	if remaining > 0 {
+		logs.Debugf(mc.Target, "Changed: %s has %d targets to update", mc, remaining)
+		return true, nil
+	}
+	if mc.makefileTimes != nil && !mc.makefileTimes.equal(mc.statMakefiles()) {
+		// A makefile was edited, which can change what gets built.
+		logs.Debugf(mc.Target, "Changed: makefiles for %s were modified", mc)
+		return true, nil
+	}
+	if mc.watched != nil {
+		watched, err := mc.snapshotWatchPaths()
+		if err != nil {
+			return false, err
+		}
+		if !watched.equal(mc.watched) {
+			// The watched paths could be used by the makefiles.
+			logs.Debugf(mc.Target, "Changed: watched paths for %s were modified", mc)
+			mc.Invalidate()
+			return true, nil
+		}
+	}
+	if mc.opts.GraphChanges && prev != nil && !prev.SameGraph(mc.db, mc.Target) {
+		logs.Debugf(mc.Target, "Changed: the dependency graph for %s is different", mc)
+		return true, nil
+	}
+	logs.Debugf(mc.Target, "No changes for %s", mc)
+	return false, nil
 }
 
 // UpdateProgress checks how many targets need updating, and stores
 // the result. It also updates the internal time to be used by HasChanged.
-func (mc *Cmd) UpdateProgress() {
+func (mc *Cmd) UpdateProgress() error {
 	if mc.usedChanged {
 		panic("Cannot use UpdateProgress after HasChanged")
 	}
 	mc.progressed = time.Now()
-	mc.remaining = mc.getRemaining()
+	mc.since = mc.progressed
+	if len(mc.opts.WatchPaths) != 0 {
+		watched, err := mc.snapshotWatchPaths()
+		if err != nil {
+			return err
+		}
+		if !watched.equal(mc.watched) {
+			logs.Debugf(mc.Target, "Watching %d files for %s: %s", len(watched), mc, strings.Join(watched.names(), " "))
+		}
+		mc.watched = watched
+	}
+	db, err := mc.getDatabase()
+	if err != nil {
+		return err
+	}
+	mc.since = mc.baseline(db)
+	mc.remaining = db.GetPendingTargets(mc.Target, mc.since)
+	mc.makefileTimes = mc.statMakefiles()
+	return nil
 }
 
 // CheckProgress returns the number of targets that need to be updated. This
@@ -101,40 +387,280 @@ func (mc *Cmd) CheckProgress() (remaining int) {
 	return mc.remaining
 }
 
+// PendingTargets returns the names of the targets that needed updating at
+// the last check. It is empty when the target is up to date.
+func (mc *Cmd) PendingTargets() []string {
+	if mc.db == nil {
+		return nil
+	}
+	return mc.db.GetPendingTargetNames(mc.Target, mc.since)
+}
+
+// WatchedFiles returns how many files from the WatchPaths option
+// were found at the last check.
+func (mc *Cmd) WatchedFiles() int {
+	return len(mc.watched)
+}
+
+// checked calls the OnCheck option, if there is one.
+func (mc *Cmd) checked() {
+	if mc.opts.OnCheck != nil {
+		mc.opts.OnCheck(mc)
+	}
+}
+
+// Name returns the name of the command's target, or the name of the
+// default goal if there is no target and the database has been loaded.
+func (mc *Cmd) Name() string {
+	if len(mc.Target) != 0 || mc.db == nil {
+		return mc.Target
+	}
+	return mc.db.GetTarget("").Name
+}
+
+// Duration returns how long the make command ran for,
+// or how long it has been running for so far.
+func (mc *Cmd) Duration() time.Duration {
+	return mc.cmd.Duration()
+}
+
+// IsRunning reports whether the make command is running.
+func (mc *Cmd) IsRunning() bool {
+	return mc.cmd.IsRunning()
+}
+
 // String returns the underlying make command that gets run.
 func (mc *Cmd) String() string {
 	return mc.cmd.String()
 }
 
-// getDatabase runs the make query for this make command's
-// target, and populates a new database with the results.
-func (mc *Cmd) getDatabase() *makedb.Database {
-	cmd := exec.Command("make", mc.queryArgs...)
-	out, _ := cmd.Output()
+// Refresh runs the make query for this make command's
+// target, and stores a new database with the results.
+func (mc *Cmd) Refresh() error {
+	cmd := exec.Command(mc.opts.Make, mc.queryArgs...)
+	cmd.Dir = mc.opts.Dir
+	// The build command streams its stderr live, but the query's stderr is
+	// captured, because it explains why the query failed if it does.
+	errBuf := new(bytes.Buffer)
+	cmd.Stderr = errBuf
+	out, err := cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		// With --question, make exits with status 1 when
+		// the target is not up to date. That is expected.
+		err = nil
+	}
+	stderr := bytes.TrimSpace(errBuf.Bytes())
+	if err == nil && len(stderr) != 0 {
+		logs.Debugf(mc.Target, "Make query for %s printed to stderr: %s", mc.queryArgs, stderr)
+	}
+	if err != nil {
+		if len(stderr) != 0 {
+			return fmt.Errorf("could not read make database: %s", stderr)
+		}
+		return fmt.Errorf("make query for %s: %s", mc.queryArgs, err)
+	}
 	r := bytes.NewReader(out)
 	db := makedb.NewDatabase()
-	err := db.Populate(r)
-	if err != nil {
-		log.Fatalf("getDatabase for %s: %s", mc.queryArgs, err)
+	db.Exclude = mc.opts.Exclude
+	flavor := makedb.GNU
+	if mc.opts.Capabilities != nil {
+		flavor = mc.opts.Capabilities.Flavor
+	}
+	if err := makedb.NewParser(flavor).Populate(&db, r); err != nil {
+		if len(stderr) != 0 {
+			return fmt.Errorf("could not read make database: %s: %s", err, stderr)
+		}
+		return fmt.Errorf("could not read make database for %s: %s", mc.queryArgs, err)
+	}
+	name := mc.Target
+	if len(name) == 0 {
+		name = db.DefaultGoal
+	}
+	if t, found := db.Targets[name]; !found || len(t.Name) == 0 {
+		// Checking this here avoids panics when the database is used.
+		return fmt.Errorf("could not read make database: target %q not found", name)
 	}
 	mc.db = &db
-	return &db
+	mc.refreshed = time.Now()
+	if mc.opts.CacheDatabase {
+		mc.cacheKey = mc.statMakefiles()
+	}
+	return nil
+}
+
+// Invalidate discards the cached make database, if there is one,
+// so that the make query runs again on the next check.
+func (mc *Cmd) Invalidate() {
+	mc.cacheKey = nil
+}
+
+// Database runs the make query, or reuses a recent result,
+// and returns the make database for this make command's target.
+func (mc *Cmd) Database() (*makedb.Database, error) {
+	return mc.getDatabase()
+}
+
+// getDatabase returns the make database for this make command's target.
+// The last database is reused if it is more recent than the MaxAge option,
+// or if the CacheDatabase option is used and the makefiles haven't changed.
+// Otherwise the make query is run again.
+func (mc *Cmd) getDatabase() (*makedb.Database, error) {
+	if mc.db != nil && time.Since(mc.refreshed) < mc.opts.MaxAge {
+		return mc.db, nil
+	}
+	if mc.db != nil && mc.cacheKey != nil && mc.cacheKey.equal(mc.statMakefiles()) {
+		complete, err := mc.db.Restat(mc.dir(), mc.Target)
+		if err != nil {
+			return nil, err
+		}
+		if complete {
+			return mc.db, nil
+		}
+	}
+	if err := mc.Refresh(); err != nil {
+		return nil, err
+	}
+	return mc.db, nil
+}
+
+// statMakefiles returns the modification times of the makefiles
+// in the make database. Missing makefiles have zero times.
+func (mc *Cmd) statMakefiles() watchSnapshot {
+	times := watchSnapshot{}
+	for _, name := range mc.db.Makefiles() {
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(mc.opts.Dir, name)
+		}
+		var mtime time.Time
+		if info, err := os.Stat(name); err == nil {
+			mtime = info.ModTime()
+		}
+		times[name] = mtime
+	}
+	return times
 }
 
 // getRemaining returns the number of targets that need to be updated
 // for this make command's target to be considered up to date.
-func (mc *Cmd) getRemaining() (count int) {
-	return mc.getDatabase().GetPendingTargets(mc.Target, mc.progressed)
+func (mc *Cmd) getRemaining() (count int, err error) {
+	db, err := mc.getDatabase()
+	if err != nil {
+		return 0, err
+	}
+	return db.GetPendingTargets(mc.Target, mc.since), nil
+}
+
+// baseline returns the time to compare prerequisite modification
+// times against, according to the MtimeBaseline option.
+func (mc *Cmd) baseline(db *makedb.Database) time.Time {
+	if mc.opts.MtimeBaseline == NewestPrerequisiteBaseline {
+		return db.NewestPrerequisite(mc.Target)
+	}
+	return mc.progressed
+}
+
+// roundDuration rounds a duration for display purposes.
+func roundDuration(d time.Duration) time.Duration {
+	if d < time.Second {
+		return d.Round(time.Millisecond)
+	}
+	return d.Round(100 * time.Millisecond)
+}
+
+// isVariable reports whether a make argument is a variable assignment.
+func isVariable(arg string) bool {
+	return !strings.HasPrefix(arg, "-") && strings.Contains(arg, "=")
+}
+
+// databaseOptions are the make options that change which makefiles are
+// read, or how they are read. The value is whether the option takes an
+// argument.
+var databaseOptions = map[string]bool{
+	"-e":                      false,
+	"--environment-overrides": false,
+	"-f":                      true,
+	"--file":                  true,
+	"--makefile":              true,
+	"-I":                      true,
+	"--include-dir":           true,
+	"-r":                      false,
+	"--no-builtin-rules":      false,
+	"-R":                      false,
+	"--no-builtin-variables":  false,
+}
+
+// databaseArgs returns the make arguments that can affect the make
+// database, so that the query reads the same makefiles with the same
+// variables as the build.
+func databaseArgs(args []string) (query []string) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name := arg
+		if strings.HasPrefix(arg, "--") {
+			// The long form, with an attached argument: --file=other.mk
+			if j := strings.Index(arg, "="); j != -1 {
+				name = arg[:j]
+			}
+		} else if strings.HasPrefix(arg, "-f") || strings.HasPrefix(arg, "-I") {
+			// The short form, with an attached argument: -fother.mk
+			name = arg[:2]
+		}
+		hasArg, found := databaseOptions[name]
+		switch {
+		case isVariable(arg):
+			// Variables can affect the dependency graph,
+			// so the query needs them too.
+			query = append(query, arg)
+		case !found:
+			// Other options do not affect the database.
+		case hasArg && name == arg && i+1 < len(args):
+			query = append(query, arg, args[i+1])
+			i++
+		default:
+			query = append(query, arg)
+		}
+	}
+	return query
+}
+
+// finished handles the command exiting by itself.
+func (mc *Cmd) finished(err error) {
+	if err == nil {
+		name := mc.Name()
+		if len(name) == 0 {
+			name = "default goal"
+		}
+		logs.Successf(mc.Target, "built %s in %s", name, roundDuration(mc.Duration()))
+	}
+	if mc.opts.OnFinish != nil {
+		mc.opts.OnFinish(mc, err)
+	}
+}
+
+// Stop ends the command so that it can be restarted. The command is killed,
+// unless it has been configured to finish its current build instead.
+func (mc *Cmd) Stop() {
+	if mc.opts.FinishCurrent && mc.cmd.IsRunning() {
+		logs.Warnf(mc.Target, "Waiting for %s to finish", mc)
+		mc.finished(<-mc.cmd.Finished())
+		return
+	}
+	mc.Kill()
 }
 
-// mustKill tries to kill the command and waits for it to finish.
-// It will keep trying if there is a problem.
-func (mc *Cmd) mustKill() {
+// Kill tries to kill the command and waits for it to finish, even if it has
+// been configured to finish its current build. It will keep trying if there
+// is a problem.
+func (mc *Cmd) Kill() {
 	for {
+		running := mc.cmd.IsRunning()
 		if err := mc.cmd.Kill(); err != nil {
-			log.Printf(colors.Red("Remake: Error killing %s: %s"), mc, err)
+			logs.Errorf(mc.Target, "Error killing %s: %s", mc, err)
 			time.Sleep(1 * time.Second)
 		} else {
+			if running {
+				logs.Infof(mc.Target, "Killed %s after %s", mc, roundDuration(mc.Duration()))
+			}
 			return
 		}
 	}
@@ -1,12 +1,34 @@
 package makecmd
 
 import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/raymondbutcher/remake/makedb"
 )
 
+const testDir = "tests"
+
+// touchTestFile creates or updates a file in the test directory,
+// setting its modification time.
+func touchTestFile(t *testing.T, name string, mtime time.Time) {
+	path := filepath.Join(testDir, name)
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestGetFiles(t *testing.T) {
 	cmd := Cmd{
 		db: &makedb.Database{
@@ -29,15 +51,527 @@ func TestGetFiles(t *testing.T) {
 
 	cmd.Target = ""
 	expected := "t1,t2,t3"
-	got := strings.Join(cmd.GetFiles(), ",")
+	files, err := cmd.GetFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(files, ",")
 	if got != expected {
 		t.Errorf("Expected %s but got %s", expected, got)
 	}
 
 	cmd.Target = "t2"
 	expected = "t2,t3"
-	got = strings.Join(cmd.GetFiles(), ",")
+	files, err = cmd.GetFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = strings.Join(files, ",")
 	if got != expected {
 		t.Errorf("Expected %s but got %s", expected, got)
 	}
 }
+
+func TestGetFilesPhony(t *testing.T) {
+	cmd := Cmd{
+		db: &makedb.Database{
+			Targets: map[string]*makedb.Target{
+				"all": {
+					Name:                "all",
+					Phony:               true,
+					NormalPrerequisites: []string{"build", "docs/index.html"},
+				},
+				"build": {
+					Name:                "build",
+					Phony:               true,
+					NormalPrerequisites: []string{"bin/app"},
+				},
+				"bin/app":         {Name: "bin/app"},
+				"docs/index.html": {Name: "docs/index.html"},
+			},
+		},
+		Target: "all",
+	}
+
+	// Phony targets are skipped, but their prerequisites are not.
+	files, err := cmd.GetFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	expected := "bin/app,docs/index.html"
+	if got := strings.Join(files, ","); got != expected {
+		t.Errorf("Expected %s but got %s", expected, got)
+	}
+}
+
+func TestGetWatchDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "src"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := Cmd{
+		db: &makedb.Database{
+			Targets: map[string]*makedb.Target{
+				"app": {
+					Name:                "app",
+					NormalPrerequisites: []string{"src", "src/main.c", "lib/util.c", "src/gen/version.h"},
+				},
+				"src":               {Name: "src"},
+				"src/main.c":        {Name: "src/main.c"},
+				"lib/util.c":        {Name: "lib/util.c"},
+				"src/gen/version.h": {Name: "src/gen/version.h", DoesNotExist: true},
+			},
+		},
+		Target: "app",
+		opts:   Options{Dir: dir},
+	}
+
+	dirs, err := cmd.GetWatchDirs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Missing files use their nearest existing directory,
+	// and lib doesn't exist, so the make directory is used.
+	expected := ".,src"
+	if got := strings.Join(dirs, ","); got != expected {
+		t.Errorf("Expected %s but got %s", expected, got)
+	}
+
+	// Once a missing directory is created, it is used instead.
+	if err := os.MkdirAll(filepath.Join(dir, "src", "gen"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	dirs, err = cmd.GetWatchDirs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = ".,src,src/gen"
+	if got := strings.Join(dirs, ","); got != expected {
+		t.Errorf("Expected %s but got %s", expected, got)
+	}
+}
+
+func TestNewCmdArgs(t *testing.T) {
+	cmd := NewCmd("t1", Options{Args: []string{"-j4", "CFLAGS=-O2"}})
+
+	expected := "make --warn-undefined-variables -j4 CFLAGS=-O2 t1"
+	if got := cmd.String(); got != expected {
+		t.Errorf("Expected %s but got %s", expected, got)
+	}
+
+	expected = "--warn-undefined-variables,--question,--print-data-base,CFLAGS=-O2,t1"
+	if got := strings.Join(cmd.queryArgs, ","); got != expected {
+		t.Errorf("Expected %s but got %s", expected, got)
+	}
+
+	cmd = NewCmd("t1", Options{Args: []string{"-j4"}, OutputSync: "target"})
+
+	expected = "make --warn-undefined-variables --output-sync=target -j4 t1"
+	if got := cmd.String(); got != expected {
+		t.Errorf("Expected %s but got %s", expected, got)
+	}
+
+	expected = "--warn-undefined-variables,--question,--print-data-base,t1"
+	if got := strings.Join(cmd.queryArgs, ","); got != expected {
+		t.Errorf("Expected %s but got %s", expected, got)
+	}
+
+	// Options that change which makefiles are read are used by the query.
+	cmd = NewCmd("t1", Options{Args: []string{
+		"-f", "other.mk", "-j", "4", "--include-dir=inc", "-Iinc2", "-r", "--makefile", "third.mk", "-k",
+	}})
+
+	expected = "--warn-undefined-variables,--question,--print-data-base,-f,other.mk,--include-dir=inc,-Iinc2,-r,--makefile,third.mk,t1"
+	if got := strings.Join(cmd.queryArgs, ","); got != expected {
+		t.Errorf("Expected %s but got %s", expected, got)
+	}
+
+	cmd = NewCmd("t1", Options{ExtraGoals: []string{"t2", "t3"}})
+
+	expected = "make --warn-undefined-variables t1 t2 t3"
+	if got := cmd.String(); got != expected {
+		t.Errorf("Expected %s but got %s", expected, got)
+	}
+
+	expected = "--warn-undefined-variables,--question,--print-data-base,t1"
+	if got := strings.Join(cmd.queryArgs, ","); got != expected {
+		t.Errorf("Expected %s but got %s", expected, got)
+	}
+}
+
+func TestDir(t *testing.T) {
+	// Use the Makefile from the makedb tests, which has "f1" as its
+	// default goal. There is no Makefile in the current directory.
+	cmd := NewCmd("", Options{Dir: "../makedb/tests"})
+	if err := cmd.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if got := cmd.Name(); got != "f1" {
+		t.Errorf("Expected f1 but got %s", got)
+	}
+
+	// Another goal in another directory uses its own Makefile.
+	other := NewCmd("", Options{Dir: testDir})
+	if err := other.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if got := other.Name(); got != "phony" {
+		t.Errorf("Expected phony but got %s", got)
+	}
+}
+
+func TestMakefileArg(t *testing.T) {
+	// There is no Makefile, so the query only works with the -f option.
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "other.mk"), []byte("other:\n\ttouch other\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := NewCmd("", Options{Dir: dir, Args: []string{"-f", "other.mk"}})
+	if err := cmd.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if got := cmd.Name(); got != "other" {
+		t.Errorf("Expected other but got %s", got)
+	}
+}
+
+func TestMaxAge(t *testing.T) {
+	mustGetDatabase := func(cmd *Cmd) *makedb.Database {
+		db, err := cmd.getDatabase()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+
+	cmd := NewCmd("", Options{Dir: "../makedb/tests"})
+	if mustGetDatabase(cmd) == mustGetDatabase(cmd) {
+		t.Error("Expected the database to be refreshed by default")
+	}
+
+	cmd = NewCmd("", Options{Dir: "../makedb/tests", MaxAge: time.Hour})
+	if mustGetDatabase(cmd) != mustGetDatabase(cmd) {
+		t.Error("Expected the database to be reused within MaxAge")
+	}
+	db := mustGetDatabase(cmd)
+	if err := cmd.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if mustGetDatabase(cmd) == db {
+		t.Error("Expected Refresh to replace the database")
+	}
+}
+
+func TestCacheDatabase(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	write := func(name string, content string, mtime time.Time) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("Makefile", "out: src\n\tcp src out\n", now.Add(-time.Hour))
+	write("src", "", now.Add(-time.Minute))
+	write("out", "", now)
+
+	cmd := NewCmd("out", Options{Dir: dir, CacheDatabase: true})
+	db, err := cmd.getDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(expectSame bool, expectPending int) {
+		t.Helper()
+		got, err := cmd.getDatabase()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if same := got == db; same != expectSame {
+			t.Errorf("Expected reused database to be %t", expectSame)
+		}
+		if count := got.GetPendingTargets("out", now); count != expectPending {
+			t.Errorf("Expected %d pending targets, got %d", expectPending, count)
+		}
+		db = got
+	}
+
+	// The database is reused, with file changes.
+	check(true, 0)
+	write("src", "", now.Add(time.Minute))
+	check(true, 1)
+
+	// Changing the Makefile runs the make query again.
+	write("Makefile", "out: src\n\tcp src out\n", now)
+	check(false, 1)
+
+	// So does invalidating it.
+	cmd.Invalidate()
+	check(false, 1)
+}
+
+func TestQueryError(t *testing.T) {
+	// There is no Makefile in this directory, so make exits with an error.
+	cmd := NewCmd("", Options{})
+	if err := cmd.UpdateProgress(); err == nil {
+		t.Error("Expected an error from UpdateProgress")
+	}
+	if _, err := cmd.GetFiles(); err == nil {
+		t.Error("Expected an error from GetFiles")
+	}
+}
+
+func TestQueryErrorMessages(t *testing.T) {
+	dir := graceTestDir(t, "out:\n  echo missing tab\n")
+	script := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+content+"\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	for _, test := range []struct {
+		name string
+		make string
+		want string
+	}{
+		// Make's stderr explains what is wrong with the Makefile.
+		{"bad makefile", "", "missing separator"},
+		// Something that prints no database at all.
+		{"no database", script("quiet-make", ""), "no files section"},
+		// Exit status 1 is expected from --question, but stderr is still
+		// included when there is no database.
+		{"stderr", script("broken-make", "echo 'broken make' >&2; exit 1"), "make database: broken make"},
+	} {
+		cmd := NewCmd("out", Options{Dir: dir, Make: test.make})
+		_, err := cmd.Database()
+		if err == nil || !strings.Contains(err.Error(), "could not read make database") || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: expected an error about %q, got %v", test.name, test.want, err)
+		}
+	}
+}
+
+func TestStopKill(t *testing.T) {
+	// A long-running command is killed straight away by default.
+	cmd := Cmd{cmd: NewCmdProcess("sleep", "10")}
+	if err := cmd.cmd.Start(); err != nil {
+		t.Fatalf("Could not start command: %s", err)
+	}
+	start := time.Now()
+	cmd.Stop()
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the command to be killed but it took %s", elapsed)
+	}
+	if cmd.cmd.IsRunning() {
+		t.Error("Expected the command to have stopped.")
+	}
+}
+
+func TestStopFinishCurrent(t *testing.T) {
+	// With FinishCurrent, the command is allowed to finish by itself.
+	cmd := Cmd{
+		cmd:  NewCmdProcess("sleep", "0.5"),
+		opts: Options{FinishCurrent: true},
+	}
+	if err := cmd.cmd.Start(); err != nil {
+		t.Fatalf("Could not start command: %s", err)
+	}
+	start := time.Now()
+	cmd.Stop()
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("Expected the command to finish but it took %s", elapsed)
+	}
+	if cmd.cmd.IsRunning() {
+		t.Error("Expected the command to have stopped.")
+	}
+}
+
+func TestUpToDatePhony(t *testing.T) {
+	// Start with a phony target whose prerequisites are already built.
+	past := time.Now().Add(-time.Hour)
+	touchTestFile(t, "file1", past)
+	touchTestFile(t, "file2", past)
+	defer os.Remove(filepath.Join(testDir, "file1"))
+	defer os.Remove(filepath.Join(testDir, "file2"))
+
+	cmd := NewCmd("phony", Options{Dir: testDir})
+	if err := cmd.UpdateProgress(); err != nil {
+		t.Fatal(err)
+	}
+	if remaining := cmd.CheckProgress(); remaining != 0 {
+		t.Errorf("Expected 0 remaining but got %d", remaining)
+	}
+
+	// Its real prerequisites are the files to watch.
+	files, err := cmd.GetFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(files, ","); got != "file1,file2" {
+		t.Errorf("Expected file1,file2 but got %s", got)
+	}
+
+	// Nothing has changed yet.
+	changed, err := cmd.HasChanged()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed {
+		t.Error("Expected no changes")
+	}
+
+	// Editing a prerequisite should trigger a rebuild.
+	touchTestFile(t, "file1", time.Now().Add(time.Second))
+	changed, err = cmd.HasChanged()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Error("Expected a change after editing file1")
+	}
+}
+
+func TestIncludedMakefileChanged(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	write := func(name string, content string, mtime time.Time) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("Makefile", "include inc.mk\nout: src\n\tcp src out\n", now.Add(-time.Hour))
+	write("inc.mk", "FLAGS := -x\n", now.Add(-time.Hour))
+	write("src", "", now.Add(-time.Minute))
+	write("out", "", now)
+
+	cmd := NewCmd("out", Options{Dir: dir})
+	if err := cmd.UpdateProgress(); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := cmd.HasChanged(); err != nil {
+		t.Fatal(err)
+	} else if changed {
+		t.Error("Expected no changes")
+	}
+
+	// Editing the included makefile counts as a change.
+	write("inc.mk", "FLAGS := -y\n", now.Add(time.Minute))
+	if changed, err := cmd.HasChanged(); err != nil {
+		t.Fatal(err)
+	} else if !changed {
+		t.Error("Expected the included makefile to count as a change")
+	}
+}
+
+func TestSettle(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	write := func(name string, content string, mtime time.Time) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("Makefile", "out: src\n\t@sleep 0.2; touch out\n", now.Add(-time.Hour))
+	write("src", "", now.Add(-time.Minute))
+	write("out", "", now.Add(-time.Hour))
+
+	// The running build brings the target up to date within the settle
+	// period, so it does not need to be stopped.
+	cmd := NewCmd("out", Options{Dir: dir, Settle: 5 * time.Second})
+	if err := cmd.UpdateProgress(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if cmd.settle() {
+		t.Error("Expected the target to be up to date after settling")
+	}
+}
+
+func TestNewCmdQuiet(t *testing.T) {
+	cmd := NewCmd("t1", Options{Quiet: true})
+	if cmd.cmd.cmd.Stdout != ioutil.Discard {
+		t.Error("Expected the standard output to be discarded")
+	}
+	if cmd.cmd.cmd.Stderr != os.Stderr {
+		t.Error("Expected the standard error to be shown")
+	}
+}
+
+func TestExcludeTarget(t *testing.T) {
+	dir := graceTestDir(t, "app: main.c stamp\n\t@touch app\n\nstamp: FORCE\n\t@touch stamp\n\n.PHONY: FORCE\nFORCE:\n")
+	past := time.Now().Add(-time.Hour)
+	if err := ioutil.WriteFile(filepath.Join(dir, "main.c"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(dir, "main.c"), past, past); err != nil {
+		t.Fatal(err)
+	}
+	build := exec.Command("make", "app")
+	build.Dir = dir
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+
+	// The stamp file is always out of date, so it forces rebuilds.
+	cmd := NewCmd("app", Options{Dir: dir})
+	if err := cmd.UpdateProgress(); err != nil {
+		t.Fatal(err)
+	}
+	if remaining := cmd.CheckProgress(); remaining == 0 {
+		t.Error("Expected the stamp file to make app out of date")
+	}
+
+	// Unless it is excluded.
+	cmd = NewCmd("app", Options{Dir: dir, Exclude: []string{"stamp"}})
+	if err := cmd.UpdateProgress(); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := cmd.HasChanged()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed {
+		t.Errorf("Expected no changes with stamp excluded, pending %v", cmd.PendingTargets())
+	}
+	files, err := cmd.GetFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(files, ","); got != "app,main.c" {
+		t.Errorf("Expected app,main.c but got %s", got)
+	}
+
+	// Changes to the other prerequisites still count.
+	future := time.Now().Add(time.Second)
+	if err := os.Chtimes(filepath.Join(dir, "main.c"), future, future); err != nil {
+		t.Fatal(err)
+	}
+	changed, err = cmd.HasChanged()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Error("Expected a change to main.c to be detected")
+	}
+}
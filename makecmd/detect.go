@@ -0,0 +1,35 @@
+package makecmd
+
+import "github.com/raymondbutcher/remake/logs"
+
+// DetectMode waits for the make command's target to be up to date, and then
+// waits for it to change. It never runs the make command, so it is up to
+// something else to build the target. It returns the pending targets when
+// a change is detected, or nil when the check channel is closed. There can
+// be no pending targets if the change was to a makefile or a watched path.
+func (cmd *Cmd) DetectMode(checkChannel <-chan struct{}) (pending []string) {
+	// Wait for the target to be up to date, so that the same pending
+	// changes don't get reported again after they were already detected.
+	for {
+		if err := cmd.UpdateProgress(); err != nil {
+			logs.Errorf(cmd.Target, "%s", err)
+		} else if cmd.CheckProgress() == 0 {
+			break
+		}
+		if _, ok := <-checkChannel; !ok {
+			return nil
+		}
+	}
+	for range checkChannel {
+		if changed, err := cmd.HasChanged(); err != nil {
+			logs.Errorf(cmd.Target, "%s", err)
+		} else if changed {
+			pending = cmd.PendingTargets()
+			if pending == nil {
+				pending = []string{}
+			}
+			return pending
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package makecmd
+
+import (
+	"strings"
+
+	"github.com/raymondbutcher/remake/logs"
+)
+
+// DryRunMode logs which targets need building, without running the make
+// command. It checks again each time the check channel receives, and logs
+// whenever the result is different. It returns when the check channel is
+// closed.
+func (cmd *Cmd) DryRunMode(checkChannel <-chan struct{}) {
+	// Phony targets are checked against the time that this started.
+	if err := cmd.UpdateProgress(); err != nil {
+		logs.Errorf(cmd.Target, "%s", err)
+	}
+	logged := false
+	last := ""
+	for {
+		if db, err := cmd.getDatabase(); err != nil {
+			logs.Errorf(cmd.Target, "%s", err)
+		} else if _, pending, err := db.IsUpToDate(cmd.Target, cmd.since); err != nil {
+			logs.Errorf(cmd.Target, "%s", err)
+		} else if result := strings.Join(pending, " "); !logged || result != last {
+			if len(pending) == 0 {
+				logs.Infof(cmd.Target, "%s is up to date", cmd.Name())
+			} else {
+				logs.Infof(cmd.Target, "Would build %s: %s", cmd.Name(), result)
+			}
+			logged, last = true, result
+		}
+		if _, ok := <-checkChannel; !ok {
+			return
+		}
+	}
+}
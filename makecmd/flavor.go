@@ -0,0 +1,62 @@
+package makecmd
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"regexp"
+	"strconv"
+
+	"github.com/raymondbutcher/remake/makedb"
+)
+
+// gnuMakeVersion finds the version in the output of "make --version".
+var gnuMakeVersion = regexp.MustCompile(`GNU Make (\d+)\.(\d+)\S*`)
+
+// Capabilities describe a make executable,
+// so that commands only use the options that it supports.
+type Capabilities struct {
+	// Flavor is the flavor of make, which decides how its database is
+	// parsed.
+	Flavor makedb.Flavor
+
+	// Version is the version of GNU Make, such as "4.3". It is empty for
+	// other flavors, or if the version could not be found.
+	Version string
+
+	// WarnUndefined is whether it has --warn-undefined-variables.
+	WarnUndefined bool
+
+	// OutputSync is whether it has --output-sync,
+	// which was added in GNU Make 4.0.
+	OutputSync bool
+}
+
+// gnuCapabilities are assumed when a make executable hasn't been checked.
+var gnuCapabilities = Capabilities{
+	Flavor:        makedb.GNU,
+	WarnUndefined: true,
+	OutputSync:    true,
+}
+
+// DetectCapabilities runs "make --version" to find out which flavor and
+// version of make the executable is. BSD make doesn't have that option, so
+// it exits with an error. Anything else is assumed to be GNU Make, so that
+// wrappers around it still work.
+func DetectCapabilities(name string) Capabilities {
+	out, err := exec.Command(name, "--version").Output()
+	if m := gnuMakeVersion.FindSubmatch(out); m != nil {
+		major, _ := strconv.Atoi(string(m[1]))
+		return Capabilities{
+			Flavor:        makedb.GNU,
+			Version:       string(m[0][len("GNU Make "):]),
+			WarnUndefined: true,
+			OutputSync:    major >= 4,
+		}
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && !bytes.Contains(out, []byte("GNU Make")) {
+		return Capabilities{Flavor: makedb.BSD}
+	}
+	return gnuCapabilities
+}
@@ -0,0 +1,60 @@
+package makecmd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/raymondbutcher/remake/makedb"
+)
+
+func TestDetectCapabilities(t *testing.T) {
+	if caps := DetectCapabilities("make"); caps.Flavor != makedb.GNU || caps.Version == "" {
+		t.Errorf("Expected make to be GNU Make with a version, got %+v", caps)
+	}
+
+	dir := t.TempDir()
+	script := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+content+"\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	// BSD make doesn't have the --version option.
+	bmake := script("bmake", "echo 'bmake: unknown option -- -' >&2; exit 2")
+	if caps := DetectCapabilities(bmake); caps.Flavor != makedb.BSD || caps.WarnUndefined || caps.OutputSync {
+		t.Errorf("Expected BSD make without GNU options, got %+v", caps)
+	}
+
+	// GNU Make 3.81 is still the default on macOS.
+	old := script("old", "echo 'GNU Make 3.81'")
+	caps := DetectCapabilities(old)
+	if caps.Flavor != makedb.GNU || caps.Version != "3.81" || !caps.WarnUndefined || caps.OutputSync {
+		t.Errorf("Expected GNU Make 3.81 without --output-sync, got %+v", caps)
+	}
+
+	// Wrappers that print something else are assumed to be GNU Make.
+	wrapper := script("wrapper", "echo 'wrapper 1.0'")
+	if caps := DetectCapabilities(wrapper); caps.Flavor != makedb.GNU || !caps.OutputSync {
+		t.Errorf("Expected GNU Make for a wrapper, got %+v", caps)
+	}
+}
+
+func TestNewCmdCapabilities(t *testing.T) {
+	mc := NewCmd("build", Options{
+		OutputSync:   "target",
+		Capabilities: &Capabilities{Flavor: makedb.BSD},
+	})
+	for _, args := range [][]string{mc.cmd.cmd.Args[1:], mc.queryArgs} {
+		for _, arg := range args {
+			if arg == "--warn-undefined-variables" || arg == "--output-sync=target" {
+				t.Errorf("Unsupported option %s in %v", arg, args)
+			}
+		}
+		if last := args[len(args)-1]; last != "build" {
+			t.Errorf("Expected the target after the options, got %v", args)
+		}
+	}
+}
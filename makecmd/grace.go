@@ -1,15 +1,65 @@
 package makecmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/raymondbutcher/remake/logs"
 )
 
+const (
+	// extendLogInterval limits how often grace period extensions are logged.
+	extendLogInterval = 5 * time.Second
+
+	// readyCmdTimeout limits how long the ReadyCmd option can run for.
+	readyCmdTimeout = 5 * time.Second
+)
+
+// ErrStopped is returned by StartGraceMode when its check channel is closed.
+var ErrStopped = errors.New("stopped")
+
 // Use a lock to prevent multiple make commands starting up at the same
-// time. Otherwise, separate make commands with shared dependencies would
-// be able to build the same targets at the same time.
-var buildMutex sync.Mutex
+// time in the same directory. Otherwise, separate make commands with shared
+// dependencies would be able to build the same targets at the same time.
+// Commands in different directories are assumed to be independent.
+var (
+	buildMutexes     = map[string]*sync.Mutex{}
+	buildMutexesLock sync.Mutex
+)
+
+// buildMutex returns the lock for make commands that run in a directory.
+func buildMutex(dir string) *sync.Mutex {
+	if resolved, err := resolveDir(dir); err == nil {
+		dir = resolved
+	}
+	buildMutexesLock.Lock()
+	defer buildMutexesLock.Unlock()
+	mutex := buildMutexes[dir]
+	if mutex == nil {
+		mutex = new(sync.Mutex)
+		buildMutexes[dir] = mutex
+	}
+	return mutex
+}
+
+// resolveDir returns the absolute path of a directory, with any symlinks
+// resolved, so that the same directory always has the same path.
+func resolveDir(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		return resolved, nil
+	}
+	return abs, nil
+}
 
 // progressChecker is used to keep track of the make command's
 // build progress when running in grace mode.
@@ -18,6 +68,9 @@ type progressChecker struct {
 	cmd       *Cmd
 	grace     time.Duration
 	remaining int
+	pending   string
+	upToDate  time.Time
+	logged    time.Time
 }
 
 func newProgressChecker(cmd *Cmd, gracePeriod time.Duration) *progressChecker {
@@ -25,36 +78,114 @@ func newProgressChecker(cmd *Cmd, gracePeriod time.Duration) *progressChecker {
 	pc.stalled = time.After(gracePeriod)
 	pc.cmd = cmd
 	pc.grace = gracePeriod
+	pc.logged = time.Now()
 	return pc
 }
 
-func (pc *progressChecker) check() (done, progressing bool) {
-	pc.cmd.UpdateProgress()
+func (pc *progressChecker) check() (done, progressing bool, err error) {
+	if err := pc.cmd.UpdateProgress(); err != nil {
+		return false, false, err
+	}
+	pc.cmd.checked()
 	rem := pc.cmd.CheckProgress()
-	done = (rem == 0)
-	progressing = (rem != pc.remaining)
+	logs.Debugf(pc.cmd.Target, "Checked %s during the grace period: %d targets remaining", pc.cmd, rem)
+	// Make only checks prerequisites until it finds one that needs
+	// updating, so building one target can reveal the next one without
+	// changing the count. Compare the pending targets as well.
+	pending := strings.Join(pc.cmd.PendingTargets(), " ")
+	progressing = rem != pc.remaining || pending != pc.pending
 	pc.remaining = rem
+	pc.pending = pending
+	if len(pc.cmd.opts.ReadyCmd) != 0 && pc.cmd.opts.StableFor == 0 {
+		// The readiness probe decides when the target is done.
+		done = pc.cmd.probeReady()
+	} else {
+		done = pc.isStable(rem == 0)
+		if rem == 0 && !done {
+			// The target is up to date but it has not been stable for long
+			// enough yet. Treat this as progress so it is not killed.
+			progressing = true
+		}
+		if done && len(pc.cmd.opts.ReadyCmd) != 0 {
+			// With both options, the readiness probe must succeed as well.
+			done = pc.cmd.probeReady()
+		}
+	}
 	if progressing && !done {
 		pc.extendGraceMode()
 	}
-	return
+	return done, progressing, nil
 }
 
+// isStable reports whether the target has been up to date for long enough
+// to leave grace mode, according to the StableFor option. Any pending
+// targets will reset the stable period.
+func (pc *progressChecker) isStable(upToDate bool) bool {
+	if !upToDate {
+		pc.upToDate = time.Time{}
+		return false
+	}
+	if pc.upToDate.IsZero() {
+		pc.upToDate = time.Now()
+	}
+	return time.Since(pc.upToDate) >= pc.cmd.opts.StableFor
+}
+
+// extendGraceMode resets the grace period after progress has been made.
+// It logs about it occasionally so that slow builds are not silent.
 func (pc *progressChecker) extendGraceMode() {
 	pc.stalled = time.After(pc.grace)
+	if time.Since(pc.logged) < extendLogInterval {
+		return
+	}
+	pc.logged = time.Now()
+	if pc.remaining == 0 {
+		logs.Warnf(pc.cmd.Target, "Waiting for %s to be stable, extending grace period", pc.cmd)
+	} else {
+		logs.Warnf(pc.cmd.Target, "Still building %s, extending grace period (%d targets remaining)", pc.cmd, pc.remaining)
+	}
+}
+
+// updateProgress is like UpdateProgress, but it logs errors instead of
+// returning them. It is used when leaving grace mode, where an error should
+// not stop monitor mode from starting. It will log the error again there.
+func (cmd *Cmd) updateProgress() {
+	if err := cmd.UpdateProgress(); err != nil {
+		logs.Errorf(cmd.Target, "%s", err)
+	}
+}
+
+// probeReady runs the ReadyCmd option in the directory that make runs in,
+// and reports whether it succeeded.
+func (cmd *Cmd) probeReady() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), readyCmdTimeout)
+	defer cancel()
+	c := exec.CommandContext(ctx, "sh", "-c", cmd.opts.ReadyCmd)
+	c.Dir = cmd.opts.Dir
+	return c.Run() == nil
 }
 
 // StartGraceMode starts the command and monitors it as it starts up,
-// waiting for it to finish updating anything required.
+// waiting for it to finish updating anything required. If the check
+// channel is closed, the command is killed and ErrStopped is returned.
 func (cmd *Cmd) StartGraceMode(
 	gracePeriod time.Duration,
 	readyChannel <-chan bool,
 	checkChannel <-chan struct{},
 ) error {
 
-	// Limit commands running in grace mode to 1 at a time.
-	buildMutex.Lock()
-	defer buildMutex.Unlock()
+	// Limit which commands can be in grace mode at the same time.
+	unlock := cmd.lockBuild()
+	defer unlock()
+
+	// Don't start the command if checking was stopped while waiting.
+	select {
+	case _, ok := <-checkChannel:
+		if !ok {
+			return ErrStopped
+		}
+	default:
+	}
 
 	if err := cmd.cmd.Start(); err != nil {
 		return fmt.Errorf("error starting %s: %s", cmd, err)
@@ -72,25 +203,57 @@ func (cmd *Cmd) StartGraceMode(
 			// A signal has been sent by "remake -ready" so leave grace mode.
 			// Also, update progress to ensure that the monitor mode checks
 			// timestamps against now onwards.
-			cmd.UpdateProgress()
+			logs.Debugf(cmd.Target, "Received the ready signal for %s", cmd)
+			cmd.updateProgress()
 			return nil
 
-		case <-cmd.cmd.Finished():
+		case <-cmd.ready:
+			// The output matched the ReadyPattern option,
+			// so treat it like the ready signal.
+			logs.Debugf(cmd.Target, "Output of %s matched the ready pattern", cmd)
+			cmd.updateProgress()
+			return nil
+
+		case err := <-cmd.cmd.Finished():
 			// The command has exited already, so leave grace mode.
 			// Also, update progress to ensure that the monitor mode checks
 			// timestamps against now onwards.
-			cmd.UpdateProgress()
+			cmd.finished(err)
+			cmd.updateProgress()
+			if err != nil && cmd.CheckProgress() != 0 {
+				// The build failed partway through. Monitor mode would see
+				// the pending targets as a change and restart it straight
+				// away, so return an error to make the caller back off.
+				return fmt.Errorf("%s failed before %s was up to date: %s", cmd, cmd.Name(), err)
+			}
 			return nil
 
-		case <-checkChannel:
-			if done, _ := progress.check(); done {
+		case _, ok := <-checkChannel:
+			if !ok {
+				cmd.Kill()
+				return ErrStopped
+			}
+			done, _, err := progress.check()
+			if err != nil {
+				// The make database could not be read, maybe due to an error
+				// in the Makefile. Wait a bit and then try again.
+				logs.Errorf(cmd.Target, "%s", err)
+				time.Sleep(errorSleep)
+			} else if done {
+				logs.Debugf(cmd.Target, "%s is up to date, leaving the grace period", cmd)
 				return nil
 			}
 
 		case <-progress.stalled:
 			// No progress has been made for some time.
 			// Give it one last chance before killing it.
-			if done, progressed := progress.check(); done {
+			logs.Debugf(cmd.Target, "No progress from %s during the grace period", cmd)
+			if done, progressed, err := progress.check(); err != nil {
+				// Without the database it isn't possible to tell.
+				logs.Errorf(cmd.Target, "%s", err)
+				progress.extendGraceMode()
+				continue
+			} else if done {
 				// Valid scenario that gets here: a long-running-process
 				// phony target, already up to date, doesn't use the
 				// "remake -ready" signal, checking disabled. (but that is not possible now!)
@@ -98,7 +261,7 @@ func (cmd *Cmd) StartGraceMode(
 			} else if progressed {
 				continue
 			}
-			cmd.mustKill()
+			cmd.Kill()
 			return fmt.Errorf("grace period exceeded: %s", cmd)
 		}
 	}
@@ -0,0 +1,253 @@
+package makecmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// graceTestDir returns a temporary directory with a Makefile.
+func graceTestDir(t *testing.T, makefile string) string {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "Makefile"), []byte(makefile), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// tickChannel returns a check channel that receives at an interval,
+// until the test has finished.
+func tickChannel(t *testing.T, interval time.Duration) <-chan struct{} {
+	ch := make(chan struct{})
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	go func() {
+		for {
+			select {
+			case <-time.After(interval):
+			case <-done:
+				return
+			}
+			select {
+			case ch <- struct{}{}:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+func TestGraceModeReady(t *testing.T) {
+	dir := graceTestDir(t, ".PHONY: server\nserver:\n\t@sleep 10\n")
+	cmd := NewCmd("server", Options{Dir: dir})
+	defer cmd.Kill()
+
+	ready := make(chan bool, 1)
+	ready <- true
+	if err := cmd.StartGraceMode(time.Minute, ready, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !cmd.cmd.IsRunning() {
+		t.Error("Expected the server to still be running after the ready signal")
+	}
+}
+
+func TestGraceModeFinished(t *testing.T) {
+	dir := graceTestDir(t, "out:\n\t@touch out\n")
+	var finished error = os.ErrInvalid
+	cmd := NewCmd("out", Options{
+		Dir: dir,
+		OnFinish: func(cmd *Cmd, err error) {
+			finished = err
+		},
+	})
+
+	if err := cmd.StartGraceMode(time.Minute, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if finished != nil {
+		t.Errorf("Expected the command to finish without an error, got %v", finished)
+	}
+}
+
+func TestGraceModeFailed(t *testing.T) {
+	dir := graceTestDir(t, "all: a b\n\t@touch all\n\na:\n\t@exit 2\n\nb:\n\t@touch b\n")
+	cmd := NewCmd("all", Options{Dir: dir})
+
+	// The build failed before the target was up to date,
+	// so it is an error rather than leaving grace mode.
+	err := cmd.StartGraceMode(time.Minute, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "exit status 2") {
+		t.Errorf("Expected an error for the failed build, got %v", err)
+	}
+
+	// A failure after the target is up to date leaves grace mode as usual.
+	dir = graceTestDir(t, "out:\n\t@touch out; exit 2\n")
+	cmd = NewCmd("out", Options{Dir: dir})
+	if err := cmd.StartGraceMode(time.Minute, nil, nil); err != nil {
+		t.Errorf("Expected no error after the target was built, got %v", err)
+	}
+}
+
+func TestGraceModeUpToDate(t *testing.T) {
+	dir := graceTestDir(t, "out:\n\t@touch out; sleep 10\n")
+	cmd := NewCmd("out", Options{Dir: dir})
+	defer cmd.Kill()
+
+	// The command keeps running after the target is up to date,
+	// so a check has to find that out.
+	if err := cmd.StartGraceMode(time.Minute, nil, tickChannel(t, 100*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if !cmd.cmd.IsRunning() {
+		t.Error("Expected the command to still be running")
+	}
+	if remaining := cmd.CheckProgress(); remaining != 0 {
+		t.Errorf("Expected no remaining targets, got %d", remaining)
+	}
+}
+
+func TestGraceModeReadyCmdStable(t *testing.T) {
+	dir := graceTestDir(t, "out:\n\t@touch out; sleep 10\n")
+	ready := filepath.Join(dir, "ready")
+	cmd := NewCmd("out", Options{
+		Dir:       dir,
+		ReadyCmd:  "test -f ready",
+		StableFor: 300 * time.Millisecond,
+	})
+	defer cmd.Kill()
+
+	// The ready command fails until after the target has been stable,
+	// so both are needed before grace mode is finished.
+	go func() {
+		time.Sleep(time.Second)
+		ioutil.WriteFile(ready, nil, 0644)
+	}()
+	start := time.Now()
+	if err := cmd.StartGraceMode(time.Minute, nil, tickChannel(t, 100*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second || elapsed > 5*time.Second {
+		t.Errorf("Expected grace mode to end after the ready command succeeded, took %s", elapsed)
+	}
+
+	// The ready command succeeds straight away, so the stable period decides.
+	cmd.Kill()
+	dir = graceTestDir(t, "out:\n\t@touch out; sleep 10\n")
+	cmd = NewCmd("out", Options{
+		Dir:       dir,
+		ReadyCmd:  "true",
+		StableFor: time.Second,
+	})
+	defer cmd.Kill()
+	start = time.Now()
+	if err := cmd.StartGraceMode(time.Minute, nil, tickChannel(t, 100*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second || elapsed > 5*time.Second {
+		t.Errorf("Expected grace mode to end after the stable period, took %s", elapsed)
+	}
+}
+
+func TestProbeReadyDir(t *testing.T) {
+	// The ready command runs in the directory that make runs in,
+	// so relative paths are relative to that, not the current directory.
+	dir := graceTestDir(t, "out:\n")
+	cmd := NewCmd("out", Options{Dir: dir, ReadyCmd: "test -f ready"})
+	if cmd.probeReady() {
+		t.Error("Expected the probe to fail before the file exists")
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "ready"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !cmd.probeReady() {
+		t.Error("Expected the probe to succeed in the make directory")
+	}
+}
+
+func TestGraceModeStalled(t *testing.T) {
+	dir := graceTestDir(t, "out:\n\t@sleep 10\n")
+	cmd := NewCmd("out", Options{Dir: dir})
+	defer cmd.Kill()
+
+	// Without any progress, the command is killed after the grace period.
+	start := time.Now()
+	err := cmd.StartGraceMode(300*time.Millisecond, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "grace period exceeded") {
+		t.Errorf("Expected the grace period to be exceeded, got %v", err)
+	}
+	if cmd.cmd.IsRunning() {
+		t.Error("Expected the command to have been killed")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the command to be killed sooner, took %s", elapsed)
+	}
+}
+
+func TestGraceModeProgressing(t *testing.T) {
+	dir := graceTestDir(t, "all: a b c\n\t@touch all\n\na b c:\n\t@sleep 0.4; touch $@\n")
+	cmd := NewCmd("all", Options{Dir: dir})
+
+	// The build takes longer than the grace period, but it makes progress
+	// more often than that, so it is allowed to finish. A check can find
+	// the target up to date just before make exits, so wait for it.
+	if err := cmd.StartGraceMode(700*time.Millisecond, nil, tickChannel(t, 100*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	cmd.cmd.exitWait.Wait()
+	if cmd.cmd.Signaled() || cmd.cmd.LastExitCode() != 0 {
+		t.Errorf("Expected the build to finish without being killed, got exit code %d", cmd.cmd.LastExitCode())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "all")); err != nil {
+		t.Errorf("Expected the target to be built: %s", err)
+	}
+}
+
+func TestGraceModeDirectories(t *testing.T) {
+	dir := graceTestDir(t, ".PHONY: server\nserver:\n\t@sleep 10\n")
+	server := NewCmd("server", Options{Dir: dir})
+	defer server.Kill()
+	ready := make(chan bool)
+	done := make(chan error, 1)
+	go func() {
+		done <- server.StartGraceMode(time.Minute, ready, nil)
+	}()
+	for !server.cmd.IsRunning() {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A command in another directory doesn't wait for the server.
+	other := NewCmd("out", Options{Dir: graceTestDir(t, "out:\n\t@touch out\n")})
+	start := time.Now()
+	if err := other.StartGraceMode(time.Minute, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the command in another directory to run straight away, took %s", elapsed)
+	}
+
+	// A command in the same directory waits until the server is ready.
+	same := NewCmd("server", Options{Dir: dir})
+	defer same.Kill()
+	sameReady := make(chan bool, 1)
+	sameReady <- true
+	sameDone := make(chan error, 1)
+	go func() {
+		sameDone <- same.StartGraceMode(time.Minute, sameReady, nil)
+	}()
+	select {
+	case <-sameDone:
+		t.Error("Expected the command in the same directory to wait")
+	case <-time.After(300 * time.Millisecond):
+	}
+	ready <- true
+	for _, ch := range []chan error{done, sameDone} {
+		if err := <-ch; err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -1,22 +1,67 @@
 package makecmd
 
+import (
+	"time"
+
+	"github.com/raymondbutcher/remake/logs"
+)
+
 // MonitorMode monitors the make command's target to see if it needs updating.
-// If it does, and the command is still running, then it will kill the command.
-// It will not return until it needs updating and it is not running.
+// If it does, and the command is still running, then it will kill the command,
+// or wait for it to finish if the FinishCurrent option is set. It will not
+// return until it needs updating and it is not running, or until the check
+// channel is closed.
 func (cmd *Cmd) MonitorMode(checkChannel <-chan struct{}) {
 	for {
 		select {
-		case <-cmd.cmd.Finished():
-			// The command exited. Don't actually do anything because
+		case err := <-cmd.cmd.Finished():
+			// The command exited. Don't do anything else because
 			// this doesn't mean that the make target needs updating.
-		case <-checkChannel:
-			if cmd.HasChanged() {
-				// The make target is no longer up to date. Kill the process
+			cmd.finished(err)
+		case _, ok := <-checkChannel:
+			if !ok {
+				// Checking has been stopped.
+				return
+			}
+			changed, err := cmd.HasChanged()
+			if err != nil {
+				// The make database could not be read, maybe due to an error
+				// in the Makefile. Wait a bit and then try again.
+				logs.Errorf(cmd.Target, "%s", err)
+				time.Sleep(errorSleep)
+				continue
+			}
+			cmd.checked()
+			if changed && cmd.settle() {
+				// The make target is no longer up to date. Stop the process
 				// if it is still running, and then return so the make command
 				// can be started again.
-				cmd.mustKill()
+				cmd.Stop()
 				return
 			}
 		}
 	}
 }
+
+// settle waits for the Settle option's duration after a change has been
+// detected while the command is running, and then reports whether the
+// target still needs updating. This gives the running build a chance to
+// pick up changes that were saved in quick succession, rather than being
+// killed and restarted for each one.
+func (cmd *Cmd) settle() (changed bool) {
+	if cmd.opts.Settle <= 0 || !cmd.cmd.IsRunning() {
+		return true
+	}
+	logs.Debugf(cmd.Target, "Waiting %s for changes to settle before stopping %s", cmd.opts.Settle, cmd)
+	select {
+	case err := <-cmd.cmd.Finished():
+		cmd.finished(err)
+	case <-time.After(cmd.opts.Settle):
+	}
+	changed, err := cmd.HasChanged()
+	if err != nil {
+		logs.Errorf(cmd.Target, "%s", err)
+		return true
+	}
+	return changed
+}
@@ -0,0 +1,60 @@
+package makecmd
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+)
+
+// maxPatternLine limits how much of a line without a newline is kept.
+const maxPatternLine = 64 * 1024
+
+// patternWriter passes output through to another writer, and checks each
+// line of the output against a pattern. When a line matches, it sends to
+// the matched channel without blocking, so only the first match is pending.
+type patternWriter struct {
+	w       io.Writer
+	pattern *regexp.Regexp
+	matched chan struct{}
+	line    []byte
+}
+
+func newPatternWriter(w io.Writer, pattern *regexp.Regexp, matched chan struct{}) *patternWriter {
+	return &patternWriter{
+		w:       w,
+		pattern: pattern,
+		matched: matched,
+	}
+}
+
+func (pw *patternWriter) Write(p []byte) (int, error) {
+	pw.line = append(pw.line, p...)
+	for {
+		i := bytes.IndexByte(pw.line, '\n')
+		if i == -1 {
+			break
+		}
+		pw.match(pw.line[:i])
+		pw.line = pw.line[i+1:]
+	}
+	if len(pw.line) != 0 {
+		// Servers can print a prompt without a newline,
+		// so check the partial line too.
+		if pw.match(pw.line) || len(pw.line) > maxPatternLine {
+			pw.line = nil
+		}
+	}
+	return pw.w.Write(p)
+}
+
+// match checks a line against the pattern and reports whether it matched.
+func (pw *patternWriter) match(line []byte) bool {
+	if !pw.pattern.Match(line) {
+		return false
+	}
+	select {
+	case pw.matched <- struct{}{}:
+	default:
+	}
+	return true
+}
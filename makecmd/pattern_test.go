@@ -0,0 +1,54 @@
+package makecmd
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestPatternWriter(t *testing.T) {
+	var out bytes.Buffer
+	matched := make(chan struct{}, 1)
+	w := newPatternWriter(&out, regexp.MustCompile(`Listening on :\d+`), matched)
+
+	// Lines can be split across writes.
+	for _, s := range []string{"Starting...\nListen", "ing on :80", "80\nok\n"} {
+		if _, err := w.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+		if s == "Starting...\nListen" {
+			select {
+			case <-matched:
+				t.Fatal("Matched before the line was written")
+			default:
+			}
+		}
+	}
+	select {
+	case <-matched:
+	default:
+		t.Error("Expected a match")
+	}
+	if got := out.String(); got != "Starting...\nListening on :8080\nok\n" {
+		t.Errorf("Output was not passed through, got %q", got)
+	}
+}
+
+func TestReadyPattern(t *testing.T) {
+	cmd := NewCmd("server", Options{
+		Dir:          testDir,
+		ReadyPattern: regexp.MustCompile(`Listening`),
+	})
+	defer cmd.Kill()
+	start := time.Now()
+	if err := cmd.StartGraceMode(time.Minute, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !cmd.cmd.IsRunning() {
+		t.Error("Expected the server to still be running")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected grace mode to end when the output matched, took %s", elapsed)
+	}
+}
@@ -0,0 +1,47 @@
+package makecmd
+
+import (
+	"bytes"
+	"io"
+)
+
+// prefixWriter passes output through to another writer, adding a prefix
+// to the start of each line. Lines can be split across writes, so it keeps
+// track of whether the next write starts a new line.
+type prefixWriter struct {
+	w       io.Writer
+	prefix  []byte
+	midLine bool
+	buf     bytes.Buffer
+}
+
+func newPrefixWriter(w io.Writer, prefix string) *prefixWriter {
+	return &prefixWriter{
+		w:      w,
+		prefix: []byte(prefix),
+	}
+}
+
+func (pw *prefixWriter) Write(p []byte) (int, error) {
+	// Build the prefixed output and write it all at once,
+	// so that it is less likely to mix with other output.
+	pw.buf.Reset()
+	for rest := p; len(rest) != 0; {
+		if !pw.midLine {
+			pw.buf.Write(pw.prefix)
+		}
+		i := bytes.IndexByte(rest, '\n')
+		if i == -1 {
+			pw.buf.Write(rest)
+			pw.midLine = true
+			break
+		}
+		pw.buf.Write(rest[:i+1])
+		pw.midLine = false
+		rest = rest[i+1:]
+	}
+	if _, err := pw.w.Write(pw.buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
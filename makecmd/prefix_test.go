@@ -0,0 +1,26 @@
+package makecmd
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := newPrefixWriter(&out, "[app] ")
+
+	// Lines can be split across writes, and one write can have many lines.
+	for _, s := range []string{"one\ntw", "o", "\nthree\nfour\n", "", "> "} {
+		n, err := w.Write([]byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != len(s) {
+			t.Errorf("Expected %d bytes written for %q but got %d", len(s), s, n)
+		}
+	}
+	expected := "[app] one\n[app] two\n[app] three\n[app] four\n[app] > "
+	if got := out.String(); got != expected {
+		t.Errorf("Expected %q but got %q", expected, got)
+	}
+}
@@ -1,21 +1,28 @@
 package makecmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 )
 
 // CmdProcess is a wrapper for exec.Cmd that helps to manage
 // and monitor its running process.
 type CmdProcess struct {
 	cmd          *exec.Cmd
+	ctx          context.Context
 	exitChannel  chan error
 	exitWait     sync.WaitGroup
 	running      bool
 	runningMutex sync.Mutex
+	started      time.Time
+	exited       time.Time
 }
 
 // Start the command process and a goroutine to help manage it.
@@ -29,18 +36,33 @@ func (c *CmdProcess) Start() error {
 
 	c.exitWait.Add(1)
 	c.running = true
+	c.started = time.Now()
 
 	// Use a goroutine to wait for the process to exit,
 	// and then send the exit status to the exit channel.
+	exited := make(chan struct{})
 	go func() {
 		err := c.cmd.Wait()
-		c.exitWait.Done()
 		c.runningMutex.Lock()
-		defer c.runningMutex.Unlock()
 		c.running = false
+		c.exited = time.Now()
+		c.runningMutex.Unlock()
+		c.exitWait.Done()
+		close(exited)
 		c.exitChannel <- err
 	}()
 
+	// Kill the process if the context is cancelled before it exits.
+	if done := c.ctx.Done(); done != nil {
+		go func() {
+			select {
+			case <-done:
+				c.Kill()
+			case <-exited:
+			}
+		}()
+	}
+
 	return nil
 }
 
@@ -50,6 +72,20 @@ func (c *CmdProcess) Finished() chan error {
 	return c.exitChannel
 }
 
+// Duration returns how long the process ran for,
+// or how long it has been running for so far.
+func (c *CmdProcess) Duration() time.Duration {
+	c.runningMutex.Lock()
+	defer c.runningMutex.Unlock()
+	if c.started.IsZero() {
+		return 0
+	}
+	if c.running {
+		return time.Since(c.started)
+	}
+	return c.exited.Sub(c.started)
+}
+
 // IsRunning returns whether the process is running at this point in time.
 func (c *CmdProcess) IsRunning() bool {
 	c.runningMutex.Lock()
@@ -57,22 +93,90 @@ func (c *CmdProcess) IsRunning() bool {
 	return c.running
 }
 
-// Kill the process and wait for it to finish.
+// LastExitCode returns the exit code of the process after it has exited.
+// It returns -1 if the process is still running, has not been started,
+// or was killed by a signal. Use Signaled to tell these cases apart.
+func (c *CmdProcess) LastExitCode() int {
+	c.runningMutex.Lock()
+	defer c.runningMutex.Unlock()
+	if c.running || c.cmd.ProcessState == nil {
+		return -1
+	}
+	return c.cmd.ProcessState.ExitCode()
+}
+
+// Signaled returns whether the process exited because of a signal.
+func (c *CmdProcess) Signaled() bool {
+	c.runningMutex.Lock()
+	defer c.runningMutex.Unlock()
+	if c.running || c.cmd.ProcessState == nil {
+		return false
+	}
+	status, ok := c.cmd.ProcessState.Sys().(syscall.WaitStatus)
+	return ok && status.Signaled()
+}
+
+// ExitCode returns the exit code from an error received from Finished.
+// It returns 0 for a nil error, and -1 if the process was killed by a
+// signal or the error did not come from the process exiting.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// KillTimeout is how long to wait for a process to exit after asking it to
+// terminate, before killing it forcefully.
+var KillTimeout = 2 * time.Second
+
+// Kill the process and its children, and wait for it to finish. They are
+// sent SIGTERM first, so that they can clean up, and then SIGKILL if the
+// process has not exited after the KillTimeout.
 func (c *CmdProcess) Kill() error {
 	if !c.IsRunning() {
 		return nil
 	}
-	// Operating system specific here. This kills the process and its
-	// children. Process.Kill() leaves child processes running on OSX.
-	pid := fmt.Sprintf("%d", c.cmd.Process.Pid)
-	kill := exec.Command("kill", pid)
-	kill.Stdout = os.Stdout
-	kill.Stderr = os.Stderr
-	err := kill.Run()
-	if err == nil {
+
+	// The process was started in its own process group, so using the
+	// negative pid sends the signal to the process and all of its children.
+	// Process.Kill() would leave the children running.
+	pgid := -c.cmd.Process.Pid
+	if err := syscall.Kill(pgid, syscall.SIGTERM); err != nil && err != syscall.ESRCH {
+		// ESRCH means that it has just exited by itself.
+		return fmt.Errorf("sending SIGTERM: %s", err)
+	}
+
+	exited := make(chan struct{})
+	go func() {
 		c.exitWait.Wait()
+		close(exited)
+	}()
+	select {
+	case <-exited:
+		return nil
+	case <-time.After(KillTimeout):
+	}
+
+	if err := syscall.Kill(pgid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		return fmt.Errorf("sending SIGKILL: %s", err)
+	}
+	<-exited
+	return nil
+}
+
+// AddEnv adds environment variables, in "key=value" form, to the
+// environment that the command will run with. It must be used before
+// the command is started.
+func (c *CmdProcess) AddEnv(env ...string) {
+	if c.cmd.Env == nil {
+		c.cmd.Env = os.Environ()
 	}
-	return err
+	c.cmd.Env = append(c.cmd.Env, env...)
 }
 
 // String returns the underlying command that gets run.
@@ -82,11 +186,19 @@ func (c *CmdProcess) String() string {
 
 // NewCmdProcess initializes a command process.
 func NewCmdProcess(name string, args ...string) *CmdProcess {
+	return NewCmdProcessContext(context.Background(), name, args...)
+}
+
+// NewCmdProcessContext initializes a command process that is killed,
+// along with its children, if the context is done before it exits.
+func NewCmdProcessContext(ctx context.Context, name string, args ...string) *CmdProcess {
 	cmd := exec.Command(name, args...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 	return &CmdProcess{
 		cmd:         cmd,
+		ctx:         ctx,
 		exitChannel: make(chan error),
 		exitWait:    sync.WaitGroup{},
 	}
@@ -1,6 +1,12 @@
 package makecmd
 
-import "testing"
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
 
 func ExampleCmdProcess() {
 	cmd := NewCmdProcess("echo", "hello from echo")
@@ -20,12 +26,102 @@ func TestCmdProcess(t *testing.T) {
 	if !cmd.IsRunning() {
 		t.Fatal("Expected it to be running.")
 	}
+	time.Sleep(100 * time.Millisecond)
 	if err := cmd.Kill(); err != nil {
 		t.Fatalf("Error during Kill: %s", err)
 	}
+	// Killed processes report how long they ran for until they were killed.
+	if d := cmd.Duration(); d < 100*time.Millisecond || d > 5*time.Second {
+		t.Errorf("Unexpected duration %s", d)
+	}
 	select {
 	case <-cmd.Finished():
 	default:
 		t.Error("Finished channel was empty.")
 	}
 }
+
+func TestCmdProcessKillChildren(t *testing.T) {
+	// The child process creates a file when it is terminated.
+	file := filepath.Join(t.TempDir(), "terminated")
+	cmd := NewCmdProcess("sh", "-c", "(trap 'touch "+file+"; exit' TERM; while true; do sleep 0.05; done) & wait")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Could not start command: %s", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := cmd.Kill(); err != nil {
+		t.Fatalf("Error during Kill: %s", err)
+	}
+	<-cmd.Finished()
+	for i := 0; i < 20; i++ {
+		if _, err := os.Stat(file); err == nil {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Error("Expected the child process to be terminated")
+}
+
+func TestCmdProcessContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := NewCmdProcessContext(ctx, "sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Could not start command: %s", err)
+	}
+	cancel()
+	select {
+	case err := <-cmd.Finished():
+		if err == nil {
+			t.Error("Expected an error from the killed process")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the process to be killed when the context was cancelled")
+	}
+}
+
+func TestCmdProcessExitCode(t *testing.T) {
+	cmd := NewCmdProcess("sh", "-c", "exit 3")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Could not start command: %s", err)
+	}
+	err := <-cmd.Finished()
+	if got := ExitCode(err); got != 3 {
+		t.Errorf("Expected exit code 3 but got %d", got)
+	}
+	if got := cmd.LastExitCode(); got != 3 {
+		t.Errorf("Expected last exit code 3 but got %d", got)
+	}
+	if cmd.Signaled() {
+		t.Error("Expected the command not to be signaled")
+	}
+
+	cmd = NewCmdProcess("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Could not start command: %s", err)
+	}
+	go cmd.Kill()
+	err = <-cmd.Finished()
+	if got := ExitCode(err); got != -1 {
+		t.Errorf("Expected exit code -1 but got %d", got)
+	}
+	if got := cmd.LastExitCode(); got != -1 {
+		t.Errorf("Expected last exit code -1 but got %d", got)
+	}
+	if !cmd.Signaled() {
+		t.Error("Expected the command to be signaled")
+	}
+
+	if got := ExitCode(nil); got != 0 {
+		t.Errorf("Expected exit code 0 for nil but got %d", got)
+	}
+}
+
+func ExampleCmdProcess_AddEnv() {
+	cmd := NewCmdProcess("sh", "-c", "echo $GREETING")
+	cmd.AddEnv("GREETING=hello from sh")
+	if err := cmd.Start(); err != nil {
+		// handle err
+	}
+	<-cmd.Finished()
+	// Output: hello from sh
+}
@@ -0,0 +1,116 @@
+package makecmd
+
+import (
+	"path/filepath"
+	"sync"
+)
+
+// Parallel is how many make commands can be in grace mode at the same time,
+// as long as they don't share any targets. Zero means one at a time in each
+// directory, without checking their targets.
+var Parallel = 0
+
+// builds schedules make commands in grace mode when Parallel is used.
+var builds = newBuildScheduler()
+
+// buildScheduler limits how many make commands are in grace mode at once,
+// and stops commands that share targets from building at the same time.
+type buildScheduler struct {
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	running map[int]buildSet
+	nextID  int
+}
+
+// buildSet is the absolute paths of a make command's target and its
+// dependencies. A nil set is unknown, so it overlaps with every other set.
+type buildSet map[string]bool
+
+func newBuildScheduler() *buildScheduler {
+	s := &buildScheduler{running: map[int]buildSet{}}
+	s.cond = sync.NewCond(&s.mutex)
+	return s
+}
+
+// acquire waits until fewer than limit builds are running and none of them
+// overlap with the set, and then it adds the set to the running builds.
+// The release function must be called when the build leaves grace mode.
+func (s *buildScheduler) acquire(limit int, set buildSet) (release func()) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for len(s.running) >= limit || s.overlaps(set) {
+		s.cond.Wait()
+	}
+	id := s.nextID
+	s.nextID++
+	s.running[id] = set
+	return func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		delete(s.running, id)
+		s.cond.Broadcast()
+	}
+}
+
+// overlaps reports whether a set shares any paths with a running build.
+func (s *buildScheduler) overlaps(set buildSet) bool {
+	for _, other := range s.running {
+		if set == nil || other == nil {
+			return true
+		}
+		for path := range set {
+			if other[path] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lockBuild waits until the make command can start building, and returns
+// a function to call when it has left grace mode. With the Parallel option,
+// it queries the make database first to find the command's dependencies.
+func (mc *Cmd) lockBuild() (unlock func()) {
+	if Parallel <= 0 {
+		mutex := buildMutex(mc.dir())
+		mutex.Lock()
+		return mutex.Unlock
+	}
+	return builds.acquire(Parallel, mc.buildSet())
+}
+
+// buildSet returns the absolute paths of the make command's target and its
+// dependencies, including phony targets. It returns nil if the make database
+// could not be read.
+func (mc *Cmd) buildSet() buildSet {
+	db, err := mc.getDatabase()
+	if err != nil {
+		return nil
+	}
+	dir, err := resolveDir(mc.dir())
+	if err != nil {
+		return nil
+	}
+	set := buildSet{}
+	add := func(name string) {
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(dir, name)
+		}
+		set[filepath.Clean(name)] = true
+	}
+	for _, goal := range append([]string{mc.Target}, mc.opts.ExtraGoals...) {
+		if len(goal) == 0 {
+			goal = db.DefaultGoal
+		}
+		add(goal)
+		if db.Targets[goal] == nil {
+			// Only the target is queried, so extra goals can be missing.
+			continue
+		}
+		nDeps, oDeps := db.GetDeps(goal)
+		for _, name := range append(nDeps, oDeps...) {
+			add(name)
+		}
+	}
+	return set
+}
@@ -0,0 +1,96 @@
+package makecmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// acquireAsync acquires a build in a goroutine, and returns a channel that
+// receives the release function once it has been acquired.
+func acquireAsync(s *buildScheduler, limit int, set buildSet) <-chan func() {
+	ch := make(chan func(), 1)
+	go func() {
+		ch <- s.acquire(limit, set)
+	}()
+	return ch
+}
+
+// expectAcquired checks whether an acquire has finished within a short time.
+func expectAcquired(t *testing.T, ch <-chan func(), expected bool) func() {
+	t.Helper()
+	select {
+	case release := <-ch:
+		if !expected {
+			t.Error("Expected the build to wait")
+		}
+		return release
+	case <-time.After(200 * time.Millisecond):
+		if expected {
+			t.Error("Expected the build to start")
+		}
+		return nil
+	}
+}
+
+func TestBuildScheduler(t *testing.T) {
+	s := newBuildScheduler()
+	a := buildSet{"/p/a": true, "/p/lib": true}
+	b := buildSet{"/p/b": true}
+	c := buildSet{"/p/c": true, "/p/lib": true}
+
+	// Builds that don't overlap run at the same time.
+	releaseA := expectAcquired(t, acquireAsync(s, 2, a), true)
+	releaseB := expectAcquired(t, acquireAsync(s, 2, b), true)
+
+	// A build that shares a dependency waits for the other one,
+	// and it also waits for the limit.
+	waitC := acquireAsync(s, 2, c)
+	expectAcquired(t, waitC, false)
+	releaseB()
+	expectAcquired(t, waitC, false)
+	releaseA()
+	releaseC := expectAcquired(t, waitC, true)
+
+	// An unknown set overlaps with everything.
+	waitUnknown := acquireAsync(s, 2, nil)
+	expectAcquired(t, waitUnknown, false)
+	releaseC()
+	releaseUnknown := expectAcquired(t, waitUnknown, true)
+	waitB := acquireAsync(s, 2, b)
+	expectAcquired(t, waitB, false)
+	releaseUnknown()
+	expectAcquired(t, waitB, true)()
+}
+
+func TestBuildSet(t *testing.T) {
+	dir := graceTestDir(t, "all: app docs\napp: main.o | bin\nmain.o:\ndocs:\nbin:\n")
+	cmd := NewCmd("app", Options{Dir: dir, ExtraGoals: []string{"docs"}})
+	set := cmd.buildSet()
+	var names []string
+	for _, name := range []string{"app", "main.o", "bin", "docs"} {
+		if !set[filepath.Join(dir, name)] {
+			names = append(names, name)
+		}
+	}
+	if len(names) != 0 {
+		t.Errorf("Expected the set to have %s, got %v", strings.Join(names, ", "), set)
+	}
+	if set[filepath.Join(dir, "all")] {
+		t.Error("Expected the set not to have targets that depend on it")
+	}
+
+	// The same directory through a symlink has the same paths,
+	// so that the builds overlap.
+	link := filepath.Join(t.TempDir(), "link")
+	if err := os.Symlink(dir, link); err != nil {
+		t.Fatal(err)
+	}
+	s := newBuildScheduler()
+	s.running[0] = set
+	if !s.overlaps(NewCmd("app", Options{Dir: link}).buildSet()) {
+		t.Error("Expected the build through the symlink to overlap")
+	}
+}
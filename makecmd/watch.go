@@ -0,0 +1,174 @@
+package makecmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/raymondbutcher/remake/gitignore"
+)
+
+// watchSnapshot holds the modification times of the files
+// matched by the WatchPaths option.
+type watchSnapshot map[string]time.Time
+
+// equal reports whether two snapshots have the same files and times.
+func (s watchSnapshot) equal(other watchSnapshot) bool {
+	if len(s) != len(other) {
+		return false
+	}
+	for name, mtime := range s {
+		if t, found := other[name]; !found || !t.Equal(mtime) {
+			return false
+		}
+	}
+	return true
+}
+
+// names returns the paths in the snapshot, sorted.
+func (s watchSnapshot) names() []string {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// snapshotWatchPaths expands the WatchPaths option and returns the
+// modification times of the matching files. Globs are expanded each time,
+// so that new files are found. Directories include the files inside them,
+// and subdirectories with the Recursive option. Dotfiles and ignored paths
+// are skipped, unless the WatchDotfiles option is used.
+func (mc *Cmd) snapshotWatchPaths() (watchSnapshot, error) {
+	if mc.opts.GitIgnore && mc.gitignore == nil {
+		// Load the .gitignore files once for each command.
+		matcher, err := gitignore.Load(mc.dir())
+		if err != nil {
+			return nil, err
+		}
+		mc.gitignore = matcher
+	}
+	snapshot := watchSnapshot{}
+	visited := map[string]bool{}
+	for _, pattern := range mc.opts.WatchPaths {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(mc.opts.Dir, pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		dotfiles := strings.HasPrefix(filepath.Base(pattern), ".")
+		for _, path := range matches {
+			if !dotfiles && mc.skipDotfile(filepath.Base(path)) {
+				// Like a shell, only match dotfiles when asked to.
+				continue
+			}
+			if err := mc.snapshotPath(snapshot, path, visited); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return snapshot, nil
+}
+
+// snapshotPath adds a file, or a directory and its files, to a snapshot.
+// The visited map holds the real paths of directories that have been added.
+func (mc *Cmd) snapshotPath(snapshot watchSnapshot, path string, visited map[string]bool) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		// It was deleted after the glob was expanded.
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if mc.ignored(path, info.IsDir()) {
+		return nil
+	}
+	snapshot[path] = info.ModTime()
+	if !info.IsDir() {
+		return nil
+	}
+	if mc.opts.Recursive {
+		// Symlinks can make loops, so only visit each real directory once.
+		real, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return err
+		}
+		if visited[real] {
+			return nil
+		}
+		visited[real] = true
+	}
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if mc.skipDotfile(file.Name()) {
+			continue
+		}
+		name := filepath.Join(path, file.Name())
+		if mc.opts.Recursive && (file.IsDir() || file.Mode()&os.ModeSymlink != 0) {
+			if err := mc.snapshotPath(snapshot, name, visited); err != nil {
+				return err
+			}
+		} else if !file.IsDir() && !mc.ignored(name, false) {
+			snapshot[name] = file.ModTime()
+		}
+	}
+	return nil
+}
+
+// vcsDirs are the version control directories that are skipped when
+// watching directories, even with the WatchDotfiles option.
+var vcsDirs = map[string]bool{
+	".git": true,
+	".hg":  true,
+	".svn": true,
+}
+
+// skipDotfile reports whether a file or directory name should be skipped
+// when watching directories, because it is a dotfile. Only version control
+// directories are skipped with the WatchDotfiles option.
+func (mc *Cmd) skipDotfile(name string) bool {
+	if vcsDirs[name] {
+		return true
+	}
+	return !mc.opts.WatchDotfiles && strings.HasPrefix(name, ".")
+}
+
+// ignored reports whether a path matches any of the Ignore patterns,
+// checking both its base name and its path relative to Dir, or if it
+// is ignored by .gitignore files with the GitIgnore option.
+func (mc *Cmd) ignored(path string, isDir bool) bool {
+	base := filepath.Base(path)
+	rel := path
+	if r, err := filepath.Rel(mc.dir(), path); err == nil {
+		rel = r
+	}
+	for _, pattern := range mc.opts.Ignore {
+		if ok, _ := filepath.Match(pattern, base); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, rel); ok {
+			return true
+		}
+	}
+	if mc.gitignore != nil && rel != "." && !strings.HasPrefix(rel, "..") {
+		return mc.gitignore.Match(filepath.ToSlash(rel), isDir)
+	}
+	return false
+}
+
+// dir returns the directory that make runs in.
+func (mc *Cmd) dir() string {
+	if len(mc.opts.Dir) == 0 {
+		return "."
+	}
+	return mc.opts.Dir
+}
@@ -0,0 +1,202 @@
+package makecmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatchPaths(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string) {
+		t.Helper()
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "templates"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	write("a.conf")
+	write("templates/index.html")
+	write("templates/.swp")
+
+	cmd := NewCmd("", Options{Dir: dir, WatchPaths: []string{"*.conf", "templates"}})
+	before, err := cmd.snapshotWatchPaths()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(before) != 3 {
+		t.Errorf("Expected 3 files, got %v", before)
+	}
+
+	// Dotfiles are skipped.
+	write("templates/.swp")
+	if after, _ := cmd.snapshotWatchPaths(); !after.equal(before) {
+		t.Error("Expected no changes")
+	}
+
+	// New files matching the globs are found.
+	write("b.conf")
+	after, err := cmd.snapshotWatchPaths()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.equal(before) {
+		t.Error("Expected a new file to be a change")
+	}
+
+	// Modified files are found.
+	before = after
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(dir, "templates", "index.html"), future, future); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := cmd.snapshotWatchPaths(); after.equal(before) {
+		t.Error("Expected a modified file to be a change")
+	}
+}
+
+func TestWatchPathsIgnore(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"node_modules", "src", "src/vendor"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"src/a.go", "src/a.tmp", "src/b.go"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := NewCmd("", Options{
+		Dir:        dir,
+		WatchPaths: []string{"*", "src/*"},
+		Ignore:     []string{"node_modules", "*.tmp", "src/b.go"},
+	})
+	snapshot, err := cmd.snapshotWatchPaths()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name := range snapshot {
+		rel, _ := filepath.Rel(dir, name)
+		switch rel {
+		case "src", "src/a.go", "src/vendor":
+		default:
+			t.Errorf("Unexpected file %s", rel)
+		}
+	}
+	if len(snapshot) != 3 {
+		t.Errorf("Expected 3 files, got %v", snapshot)
+	}
+}
+
+func TestWatchPathsRecursive(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"src/a/b", "src/skip"} {
+		if err := os.MkdirAll(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"src/a/b/c.go", "src/skip/d.go"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// This symlink loop should only be followed once.
+	if err := os.Symlink("..", filepath.Join(dir, "src", "a", "b", "loop")); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := NewCmd("", Options{Dir: dir, WatchPaths: []string{"src"}, Ignore: []string{"skip"}, Recursive: true})
+	snapshot, err := cmd.snapshotWatchPaths()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := snapshot[filepath.Join(dir, "src", "a", "b", "c.go")]; !found {
+		t.Errorf("Expected src/a/b/c.go, got %v", snapshot)
+	}
+	for name := range snapshot {
+		if strings.Contains(name, "skip") {
+			t.Errorf("Unexpected %s", name)
+		}
+	}
+
+	// It's not recursive by default.
+	cmd = NewCmd("", Options{Dir: dir, WatchPaths: []string{"src"}})
+	if snapshot, _ := cmd.snapshotWatchPaths(); len(snapshot) != 1 {
+		t.Errorf("Expected only src, got %v", snapshot)
+	}
+}
+
+func TestWatchPathsGitIgnore(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		".gitignore":     "*.o\n",
+		"src/.gitignore": "gen/\n",
+		"src/a.c":        "",
+		"src/a.o":        "",
+		"src/gen/b.c":    "",
+	} {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := NewCmd("", Options{Dir: dir, WatchPaths: []string{"src/*"}, GitIgnore: true})
+	snapshot, err := cmd.snapshotWatchPaths()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := snapshot[filepath.Join(dir, "src", "a.c")]; !found || len(snapshot) != 1 {
+		t.Errorf("Expected only src/a.c, got %v", snapshot)
+	}
+}
+
+func TestWatchPathsDotfiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{".config", ".git"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{".env", ".config/app.json", ".git/HEAD", "main.go"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names := func(opts Options) string {
+		t.Helper()
+		opts.Dir = dir
+		opts.WatchPaths = []string{"."}
+		opts.Recursive = true
+		snapshot, err := NewCmd("", opts).snapshotWatchPaths()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rel []string
+		for _, name := range snapshot.names() {
+			if r, _ := filepath.Rel(dir, name); r != "." {
+				rel = append(rel, r)
+			}
+		}
+		return strings.Join(rel, ",")
+	}
+
+	if got := names(Options{}); got != "main.go" {
+		t.Errorf("Expected only main.go, got %s", got)
+	}
+
+	// Version control directories are still skipped.
+	if got := names(Options{WatchDotfiles: true}); got != ".config,.config/app.json,.env,main.go" {
+		t.Errorf("Expected dotfiles except .git, got %s", got)
+	}
+}
@@ -0,0 +1,86 @@
+package makedb
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/raymondbutcher/remake/logs"
+)
+
+var (
+	cyclesMutex  sync.Mutex
+	warnedCycles = map[string]bool{}
+)
+
+// Cycles returns the circular dependencies that can be reached from
+// a target. Each one is a path that starts and ends with the same target.
+// Targets that are reached more than once without a cycle, such as with
+// diamond dependencies, are not included.
+func (db *Database) Cycles(targetName string) (cycles [][]string) {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := map[string]int{}
+	var path []string
+	var visit func(name string)
+	visit = func(name string) {
+		switch state[name] {
+		case visiting:
+			// This is a back-edge to a target that is still being visited,
+			// so the path from that target to here is a cycle.
+			for i := len(path) - 1; i >= 0; i-- {
+				if path[i] == name {
+					cycle := append([]string{}, path[i:]...)
+					cycles = append(cycles, append(cycle, name))
+					break
+				}
+			}
+			return
+		case visited:
+			return
+		}
+		state[name] = visiting
+		path = append(path, name)
+		t := db.GetPrerequisite(name)
+		for _, dep := range t.NormalPrerequisites {
+			visit(dep)
+		}
+		for _, dep := range t.OrderOnlyPrerequisites {
+			visit(dep)
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+	}
+	visit(targetName)
+	return
+}
+
+// warnCycles logs a warning for each circular dependency that can be
+// reached from a target. Each cycle is only reported once, because the
+// database gets checked over and over again.
+func (db *Database) warnCycles(targetName string) {
+	for _, cycle := range db.Cycles(targetName) {
+		key := cycleKey(cycle)
+		cyclesMutex.Lock()
+		warned := warnedCycles[key]
+		warnedCycles[key] = true
+		cyclesMutex.Unlock()
+		if !warned {
+			logs.Warnf(targetName, "Circular dependency: %s", strings.Join(cycle, " -> "))
+		}
+	}
+}
+
+// cycleKey returns the same key for a cycle no matter which
+// of its targets it starts from.
+func cycleKey(cycle []string) string {
+	names := cycle[:len(cycle)-1]
+	first := 0
+	for i, name := range names {
+		if name < names[first] {
+			first = i
+		}
+	}
+	return strings.Join(append(append([]string{}, names[first:]...), names[:first]...), " ")
+}
@@ -0,0 +1,51 @@
+package makedb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCycles(t *testing.T) {
+	db := &Database{
+		Targets: map[string]*Target{
+			"all":     {Name: "all", NormalPrerequisites: []string{"left", "right"}},
+			"left":    {Name: "left", NormalPrerequisites: []string{"bottom"}},
+			"right":   {Name: "right", NormalPrerequisites: []string{"bottom"}},
+			"bottom":  {Name: "bottom"},
+			"a":       {Name: "a", NormalPrerequisites: []string{"b"}},
+			"b":       {Name: "b", NormalPrerequisites: []string{"c"}},
+			"c":       {Name: "c", OrderOnlyPrerequisites: []string{"a"}},
+			"self":    {Name: "self", NormalPrerequisites: []string{"self"}},
+			"outside": {Name: "outside", NormalPrerequisites: []string{"b"}},
+		},
+	}
+	check := func(target string, expect string) {
+		t.Helper()
+		var got []string
+		for _, cycle := range db.Cycles(target) {
+			got = append(got, strings.Join(cycle, " -> "))
+		}
+		if s := strings.Join(got, ", "); s != expect {
+			t.Errorf("Expected cycles for %s to be %q, got %q", target, expect, s)
+		}
+	}
+
+	// Diamond dependencies are not cycles.
+	check("all", "")
+
+	check("a", "a -> b -> c -> a")
+	check("self", "self -> self")
+
+	// The path to the cycle is not part of it.
+	check("outside", "b -> c -> a -> b")
+
+	if cycleKey([]string{"b", "c", "a", "b"}) != cycleKey([]string{"a", "b", "c", "a"}) {
+		t.Error("Expected the same key for a cycle from different targets")
+	}
+
+	// GetDeps still returns each target once.
+	normal, _ := db.GetDeps("a")
+	if got := strings.Join(normal, " "); got != "b c" {
+		t.Errorf("Unexpected dependencies: %s", got)
+	}
+}
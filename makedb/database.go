@@ -3,6 +3,9 @@ package makedb
 import (
 	"fmt"
 	"io"
+	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -10,6 +13,12 @@ import (
 type Database struct {
 	DefaultGoal string
 	Targets     map[string]*Target
+	makefiles   []string
+
+	// Exclude contains glob patterns for target names to leave out when
+	// checking if a target is up to date, such as version stamp files
+	// that are always newer than the targets that use them.
+	Exclude []string
 }
 
 // NewDatabase returns a Database.
@@ -20,28 +29,15 @@ func NewDatabase() Database {
 }
 
 // Populate the Database from r, which should contain
-// the raw output from "make --print-data-base".
+// the raw output from "make --print-data-base" by GNU Make.
 func (db *Database) Populate(r io.Reader) error {
-	ch, dch, done := readTargets(r)
-	for {
-		select {
-		case name := <-dch:
-			db.DefaultGoal = name
-		case s := <-ch:
-			t := &Target{}
-			if err := t.Populate(s); err != nil {
-				return err
-			}
-			db.Targets[t.Name] = t
-		case <-done:
-			return nil
-		}
-	}
+	return gnuParser{}.Populate(db, r)
 }
 
 // GetDeps finds and returns the chain of dependencies for a target.
 // Results are split into 2 lists: normal prerequisites, and order-only
 // prerequisites (which should be checked for existence only).
+// Circular dependencies are logged as warnings.
 func (db *Database) GetDeps(targetName string) (normal []string, orderOnly []string) {
 
 	target, found := db.Targets[targetName]
@@ -49,45 +45,104 @@ func (db *Database) GetDeps(targetName string) (normal []string, orderOnly []str
 		panic(fmt.Sprintf("Target '%s' not found", targetName))
 	}
 
-	nq := NewUniqueQueue()
-	for _, name := range target.NormalPrerequisites {
-		nq.Push(name)
-	}
+	// The queues below skip targets that were already added, which also
+	// stops circular dependencies from looping forever. GNU Make drops
+	// those itself, but report any that make it into the database.
+	db.warnCycles(targetName)
 
+	// Excluded targets are left out, along with their own prerequisites,
+	// unless something else depends on those.
+	nq := NewUniqueQueue()
 	oq := NewUniqueQueue()
-	for _, name := range target.OrderOnlyPrerequisites {
-		oq.Push(name)
+	push := func(q *UniqueQueue, names []string) {
+		for _, name := range names {
+			if !db.Excluded(name) {
+				q.Push(name)
+			}
+		}
 	}
 
+	push(&nq, target.NormalPrerequisites)
+	push(&oq, target.OrderOnlyPrerequisites)
+
 	for nq.Len() != 0 {
 		name := nq.Pop()
 		normal = append(normal, name)
-		dep := db.GetTarget(name)
-		for _, name := range dep.NormalPrerequisites {
-			nq.Push(name)
-		}
-		for _, name := range dep.OrderOnlyPrerequisites {
-			oq.Push(name)
-		}
+		dep := db.GetPrerequisite(name)
+		push(&nq, dep.NormalPrerequisites)
+		push(&oq, dep.OrderOnlyPrerequisites)
 	}
 
 	for oq.Len() != 0 {
 		name := oq.Pop()
-		normal = append(normal, name)
-		dep := db.GetTarget(name)
-		for _, name := range dep.NormalPrerequisites {
-			// Normal prerequisites of order-only prerequesites remain
-			// as order-only prerequisites for the original target.
-			oq.Push(name)
-		}
-		for _, name := range dep.OrderOnlyPrerequisites {
-			oq.Push(name)
+		orderOnly = append(orderOnly, name)
+		dep := db.GetPrerequisite(name)
+		// Normal prerequisites of order-only prerequesites remain
+		// as order-only prerequisites for the original target.
+		push(&oq, dep.NormalPrerequisites)
+		push(&oq, dep.OrderOnlyPrerequisites)
+	}
+
+	return
+}
+
+// SameGraph reports whether a target has the same dependency graph in both
+// databases. This detects changes to the Makefile's rules, or to dynamic
+// inputs such as $(shell ...) results that determine the prerequisites.
+func (db *Database) SameGraph(other *Database, target string) bool {
+	a := db.graph(target)
+	b := other.graph(target)
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
 		}
 	}
+	return true
+}
 
+// graph returns lines describing a target and its dependencies,
+// with the prerequisites of each one.
+func (db *Database) graph(target string) (lines []string) {
+	add := func(t *Target) {
+		lines = append(lines, fmt.Sprintf(
+			"%s: %s | %s",
+			t.Name,
+			strings.Join(t.NormalPrerequisites, " "),
+			strings.Join(t.OrderOnlyPrerequisites, " "),
+		))
+	}
+	t := db.GetTarget(target)
+	add(t)
+	nDeps, oDeps := db.GetDeps(t.Name)
+	for _, name := range nDeps {
+		add(db.GetPrerequisite(name))
+	}
+	for _, name := range oDeps {
+		add(db.GetPrerequisite(name))
+	}
 	return
 }
 
+// SortedTargetNames returns the names of all targets in the database, with
+// the default goal first and the rest in alphabetical order. Use this instead
+// of ranging over Targets when the order matters, such as for display.
+func (db *Database) SortedTargetNames() []string {
+	names := make([]string, 0, len(db.Targets))
+	for name := range db.Targets {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if names[i] == db.DefaultGoal || names[j] == db.DefaultGoal {
+			return names[i] == db.DefaultGoal && names[j] != db.DefaultGoal
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
 // GetTarget returns a Target, or panics if it can't.
 func (db *Database) GetTarget(name string) (t *Target) {
 	if len(name) == 0 {
@@ -95,22 +150,87 @@ func (db *Database) GetTarget(name string) (t *Target) {
 	} else {
 		t = db.Targets[name]
 	}
-	if len(t.Name) == 0 {
+	if t == nil || len(t.Name) == 0 {
 		panic(fmt.Sprintf("Target '%s' not found", name))
 	}
 	return
 }
 
-func (db *Database) GetPendingTargets(target string, since time.Time) (count int) {
-	// For the specified target, return the number of targets (including itself
-	// and its dependencies) that are missing or need to be updated.
+// GetPrerequisite returns the Target for a prerequisite. When Make knows that
+// a target is out of date, it can stop checking before it gets to some of the
+// prerequisites, leaving them out of the database. Those are returned as
+// targets with Unknown set, rather than panicking.
+func (db *Database) GetPrerequisite(name string) *Target {
+	if t, found := db.Targets[name]; found {
+		return t
+	}
+	return &Target{Name: name, Unknown: true}
+}
 
+// NewestPrerequisite returns the newest modification time of a target's
+// prerequisites, ignoring phony targets. Comparing against this, rather
+// than the current time, avoids problems with clock skew between this
+// host and the filesystem.
+func (db *Database) NewestPrerequisite(target string) (newest time.Time) {
 	t := db.GetTarget(target)
+	nDeps, _ := db.GetDeps(t.Name)
+	for _, name := range nDeps {
+		dep := db.GetPrerequisite(name)
+		if !dep.Phony && dep.LastModified.After(newest) {
+			newest = dep.LastModified
+		}
+	}
+	return
+}
+
+// IsUpToDate reports whether a target is up to date, along with the names
+// of any targets that need updating. For phony targets, which Make always
+// considers out of date, only their prerequisites are checked. Any that are
+// newer than since are included in the names.
+func (db *Database) IsUpToDate(target string, since time.Time) (upToDate bool, pending []string, err error) {
+	name := target
+	if len(name) == 0 {
+		name = db.DefaultGoal
+	}
+	if t, found := db.Targets[name]; !found || len(t.Name) == 0 {
+		return false, nil, fmt.Errorf("target %q not found", target)
+	}
+	pending = db.GetPendingTargetNames(target, since)
+	return len(pending) == 0, pending, nil
+}
 
-	// Check the specified target.
-	if !t.Phony && (t.DoesNotExist || t.NeedsUpdate) {
+// GetPendingTargets returns the number of targets (including the target
+// itself and its dependencies) that are missing or need to be updated.
+// A phony target with prerequisites that are newer than since counts
+// once, however many of them there are.
+func (db *Database) GetPendingTargets(target string, since time.Time) (count int) {
+	names, newer := db.pendingTargets(target, since)
+	count = len(names) - newer
+	if newer != 0 {
 		count++
 	}
+	return count
+}
+
+// GetPendingTargetNames returns the names of the targets (including the
+// target itself and its dependencies) that are missing or need to be updated.
+// For phony targets, the prerequisites that are newer than since are
+// included, rather than the phony target itself.
+func (db *Database) GetPendingTargetNames(target string, since time.Time) (names []string) {
+	names, _ = db.pendingTargets(target, since)
+	return names
+}
+
+// pendingTargets returns the names for GetPendingTargetNames, and how many
+// of them are only there because they are newer than a phony target.
+func (db *Database) pendingTargets(target string, since time.Time) (names []string, newer int) {
+	t := db.GetTarget(target)
+
+	// Check the specified target. If Make has reported which prerequisites
+	// are newer than it, then it definitely needs updating.
+	if !t.Phony && (db.needsUpdate(t) || db.hasNewerPrerequisites(t)) {
+		names = append(names, t.Name)
+	}
 
 	nDeps, oDeps := db.GetDeps(t.Name)
 
@@ -118,34 +238,87 @@ func (db *Database) GetPendingTargets(target string, since time.Time) (count int
 	// This does not work with the way that Remake waits for changes.
 	// For phony targets, Remake will only check their dependencies
 	// and restart when real file targets (non-phony) dependencies
-	// have changed.
-	foundNewer := false
+	// have changed. Those dependencies are the pending ones.
 
-	// Check the target's normal prerequisites.
+	// Check the target's normal prerequisites, in the same way.
 	for _, name := range nDeps {
-		dep := db.GetTarget(name)
+		dep := db.GetPrerequisite(name)
 		if !dep.Phony {
-			if dep.DoesNotExist || dep.NeedsUpdate {
-				count++
+			if db.needsUpdate(dep) || db.hasNewerPrerequisites(dep) {
+				names = append(names, dep.Name)
 			} else if t.Phony && dep.LastModified.After(since) {
-				foundNewer = true
+				names = append(names, dep.Name)
+				newer++
 			}
 		}
 	}
 
-	if foundNewer {
-		count++
-	}
-
 	// Check the target's order-only prerequisites.
 	// This type only needs to exist (if it's not a phony target).
 
 	for _, name := range oDeps {
-		dep := db.GetTarget(name)
+		dep := db.GetPrerequisite(name)
 		if !dep.Phony && dep.DoesNotExist {
-			count++
+			names = append(names, dep.Name)
 		}
 	}
 
 	return
 }
+
+// Excluded reports whether a target name matches any of the Exclude patterns.
+func (db *Database) Excluded(name string) bool {
+	for _, pattern := range db.Exclude {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hasNewerPrerequisites reports whether Make found any prerequisites
+// that are newer than the target, other than excluded ones.
+func (db *Database) hasNewerPrerequisites(t *Target) bool {
+	for _, name := range t.NewerPrerequisites {
+		if !db.Excluded(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// needsUpdate reports whether Make says that a target is missing or needs
+// updating. Make doesn't always say why, so if the target has excluded
+// prerequisites, it only needs updating if one of the others could be the
+// reason. Prerequisites that Make didn't check could be, because Make stops
+// checking when it finds one that needs updating.
+func (db *Database) needsUpdate(t *Target) bool {
+	if t.DoesNotExist {
+		return true
+	}
+	if !t.NeedsUpdate {
+		return false
+	}
+	excluded := false
+	for _, name := range t.NormalPrerequisites {
+		if db.Excluded(name) {
+			excluded = true
+			continue
+		}
+		dep := db.GetPrerequisite(name)
+		if dep.Phony || dep.Unknown || dep.NeedsUpdate || dep.DoesNotExist || dep.LastModified.IsZero() || dep.LastModified.After(t.LastModified) {
+			return true
+		}
+	}
+	for _, name := range t.OrderOnlyPrerequisites {
+		if db.Excluded(name) {
+			excluded = true
+			continue
+		}
+		dep := db.GetPrerequisite(name)
+		if dep.Unknown || dep.DoesNotExist {
+			return true
+		}
+	}
+	return !excluded || db.hasNewerPrerequisites(t)
+}
@@ -262,3 +262,282 @@ func TestPhony(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestSameGraph(t *testing.T) {
+	newDB := func(prereqs ...string) *Database {
+		return &Database{
+			DefaultGoal: "t1",
+			Targets: map[string]*Target{
+				"t1": {Name: "t1", NormalPrerequisites: prereqs},
+				"t2": {Name: "t2"},
+				"t3": {Name: "t3"},
+			},
+		}
+	}
+
+	a := newDB("t2")
+	if !a.SameGraph(newDB("t2"), "") {
+		t.Error("Expected identical databases to have the same graph")
+	}
+	if a.SameGraph(newDB("t2", "t3"), "") {
+		t.Error("Expected an added prerequisite to change the graph")
+	}
+	if a.SameGraph(newDB("t3"), "t1") {
+		t.Error("Expected a replaced prerequisite to change the graph")
+	}
+	if !a.SameGraph(newDB("t3"), "t2") {
+		t.Error("Expected unrelated targets to be ignored")
+	}
+}
+
+func TestNewestPrerequisite(t *testing.T) {
+	// Simulate a file server with a clock that is an hour ahead.
+	now := time.Now()
+	skewed := now.Add(time.Hour)
+	db := &Database{
+		Targets: map[string]*Target{
+			"p":  {Name: "p", Phony: true, NormalPrerequisites: []string{"f1", "f2"}},
+			"f1": {Name: "f1", LastModified: skewed.Add(-time.Minute)},
+			"f2": {Name: "f2", LastModified: skewed},
+		},
+	}
+
+	// Comparing against the local clock causes a spurious rebuild.
+	if count := db.GetPendingTargets("p", now); count != 1 {
+		t.Errorf("Expected 1 pending target with the clock baseline, got %d", count)
+	}
+
+	// Comparing against the newest prerequisite does not.
+	newest := db.NewestPrerequisite("p")
+	if !newest.Equal(skewed) {
+		t.Errorf("Expected newest prerequisite time %s, got %s", skewed, newest)
+	}
+	if count := db.GetPendingTargets("p", newest); count != 0 {
+		t.Errorf("Expected 0 pending targets with the newest-prereq baseline, got %d", count)
+	}
+
+	// Then a real change is still detected.
+	db.Targets["f1"].LastModified = skewed.Add(time.Second)
+	if count := db.GetPendingTargets("p", newest); count != 1 {
+		t.Errorf("Expected 1 pending target after a change, got %d", count)
+	}
+}
+
+func TestPartialDatabase(t *testing.T) {
+	// Make stopped checking after finding that f2 was missing,
+	// so f3 and its prerequisite f4 were left out of the database.
+	db := &Database{
+		DefaultGoal: "f1",
+		Targets: map[string]*Target{
+			"f1": {Name: "f1", NeedsUpdate: true, NormalPrerequisites: []string{"f2", "f3"}},
+			"f2": {Name: "f2", DoesNotExist: true, NeedsUpdate: true},
+		},
+	}
+
+	nDeps, _ := db.GetDeps("f1")
+	if got := strings.Join(nDeps, ","); got != "f2,f3" {
+		t.Errorf("Expected f2,f3 got %s", got)
+	}
+
+	if f3 := db.GetPrerequisite("f3"); !f3.Unknown {
+		t.Error("Expected f3 to be unknown")
+	}
+
+	if count := db.GetPendingTargets("", time.Now()); count != 2 {
+		t.Errorf("Expected 2 pending targets, got %d", count)
+	}
+}
+
+func TestDefaultGoalAfterFiles(t *testing.T) {
+	out := "# Variables\n" +
+		"\n" +
+		"# Files\n" +
+		"\n" +
+		"f1: f2\n" +
+		"#  Last modified 2021-03-14 09:12:33\n" +
+		"\n" +
+		"f2:\n" +
+		"#  Last modified 2021-03-14 09:12:33\n" +
+		"\n" +
+		"# Variables\n" +
+		"\n" +
+		".DEFAULT_GOAL := f1\n"
+	db := NewDatabase()
+	if err := db.Populate(strings.NewReader(out)); err != nil {
+		t.Fatal(err)
+	}
+	if db.DefaultGoal != "f1" {
+		t.Errorf("Expected default goal f1 got %q", db.DefaultGoal)
+	}
+	if _, found := db.Targets["f2"]; !found {
+		t.Error("Expected target f2")
+	}
+}
+
+func TestIsUpToDate(t *testing.T) {
+	since := time.Date(2021, 3, 14, 9, 0, 0, 0, time.Local)
+	before := since.Add(-time.Hour)
+	after := since.Add(time.Hour)
+
+	db := &Database{
+		DefaultGoal: "all",
+		Targets: map[string]*Target{
+			"all":   {Name: "all", Phony: true, NormalPrerequisites: []string{"f1"}, OrderOnlyPrerequisites: []string{"dir"}},
+			"f1":    {Name: "f1", LastModified: before, NormalPrerequisites: []string{"f2"}},
+			"f2":    {Name: "f2", LastModified: before},
+			"dir":   {Name: "dir", LastModified: before},
+			"clean": {Name: "clean", Phony: true},
+		},
+	}
+
+	check := func(target string, expected string) {
+		t.Helper()
+		upToDate, pending, err := db.IsUpToDate(target, since)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(pending, ","); got != expected {
+			t.Errorf("%s: expected pending %q got %q", target, expected, got)
+		}
+		if upToDate != (len(expected) == 0) {
+			t.Errorf("%s: unexpected up to date %t", target, upToDate)
+		}
+	}
+
+	// Phony targets are up to date when their prerequisites haven't changed.
+	check("", "")
+	check("clean", "")
+
+	// Phony targets are out of date when their prerequisites are newer.
+	db.Targets["f2"].LastModified = after
+	check("all", "f2")
+	db.Targets["f1"].LastModified = after
+	check("all", "f1,f2")
+	if count := db.GetPendingTargets("all", since); count != 1 {
+		t.Errorf("Expected the phony target to count once, got %d", count)
+	}
+	db.Targets["f1"].LastModified = before
+	db.Targets["f2"].LastModified = before
+
+	// Missing files need updating.
+	db.Targets["f2"].DoesNotExist = true
+	check("all", "f2")
+	check("f1", "f2")
+	db.Targets["f2"].DoesNotExist = false
+
+	// So do prerequisites that Make found to have newer prerequisites.
+	db.Targets["f1"].NewerPrerequisites = []string{"f2"}
+	check("all", "f1")
+	if count := db.GetPendingTargets("all", since); count != 1 {
+		t.Errorf("Expected 1 pending target, got %d", count)
+	}
+	db.Targets["f1"].NewerPrerequisites = nil
+
+	// Order-only prerequisites only need to exist.
+	db.Targets["dir"].LastModified = after
+	db.Targets["dir"].NeedsUpdate = true
+	check("all", "")
+	db.Targets["dir"].DoesNotExist = true
+	check("all", "dir")
+
+	if _, _, err := db.IsUpToDate("missing", since); err == nil {
+		t.Error("Expected an error for a missing target")
+	}
+}
+
+func TestSortedTargetNames(t *testing.T) {
+	db := NewDatabase()
+	db.DefaultGoal = "main"
+	for _, name := range []string{"zip", "all", "main", "bin"} {
+		db.Targets[name] = &Target{Name: name}
+	}
+	if got := strings.Join(db.SortedTargetNames(), ","); got != "main,all,bin,zip" {
+		t.Errorf("Expected the default goal first, got %s", got)
+	}
+}
+
+func TestDoubleColon(t *testing.T) {
+	dir := t.TempDir()
+	makefile := "all:: a\n\t@echo a\n\nall:: b\n\t@echo b\n\na b:\n\ttouch $@\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "Makefile"), []byte(makefile), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("make", "--question", "--print-data-base")
+	cmd.Dir = dir
+	out, _ := cmd.Output()
+	db := NewDatabase()
+	if err := db.Populate(bytes.NewReader(out)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each rule has its own block, and they are merged together.
+	all := db.GetTarget("all")
+	if !all.DoubleColon {
+		t.Error("Expected a double-colon target")
+	}
+	if got := strings.Join(all.NormalPrerequisites, ","); got != "a,b" {
+		t.Errorf("Expected a,b got %s", got)
+	}
+	if got := strings.Join(all.Recipe, ","); got != "@echo a,@echo b" {
+		t.Errorf("Expected both recipes, got %s", got)
+	}
+
+	// Make stops checking after the first rule's prerequisite is missing.
+	if got := strings.Join(db.GetPendingTargetNames("all", time.Now()), ","); got != "all,a" {
+		t.Errorf("Expected all,a got %s", got)
+	}
+}
+
+func TestExclude(t *testing.T) {
+	now := time.Now()
+	before := now.Add(-time.Hour)
+
+	// The stamp file is always rebuilt, so Make says that app needs
+	// updating, without saying which prerequisite is the reason.
+	newDatabase := func() *Database {
+		return &Database{
+			DefaultGoal: "app",
+			Targets: map[string]*Target{
+				"app":    {Name: "app", NeedsUpdate: true, LastModified: now, NormalPrerequisites: []string{"main.c", "stamp"}},
+				"main.c": {Name: "main.c", LastModified: before},
+				"stamp":  {Name: "stamp", NeedsUpdate: true, LastModified: now, NormalPrerequisites: []string{"FORCE"}},
+				"FORCE":  {Name: "FORCE", Phony: true},
+			},
+		}
+	}
+	check := func(db *Database, expected string) {
+		t.Helper()
+		if got := strings.Join(db.GetPendingTargetNames("", now), ","); got != expected {
+			t.Errorf("Expected pending %q got %q", expected, got)
+		}
+	}
+
+	db := newDatabase()
+	check(db, "app,stamp")
+
+	// Excluding the stamp file stops it from forcing rebuilds.
+	db.Exclude = []string{"*stamp"}
+	check(db, "")
+	nDeps, _ := db.GetDeps("app")
+	if got := strings.Join(nDeps, ","); got != "main.c" {
+		t.Errorf("Expected main.c got %s", got)
+	}
+
+	// Real changes to the other prerequisites are still found.
+	db.Targets["main.c"].LastModified = now.Add(time.Second)
+	check(db, "app")
+
+	// Including when Make stopped before checking them.
+	db = newDatabase()
+	db.Exclude = []string{"stamp"}
+	db.Targets["main.c"].LastModified = time.Time{}
+	check(db, "app")
+
+	// Newer prerequisites that are excluded are ignored.
+	db = newDatabase()
+	db.Exclude = []string{"stamp"}
+	db.Targets["app"].NewerPrerequisites = []string{"stamp"}
+	check(db, "")
+	db.Targets["app"].NewerPrerequisites = []string{"stamp", "main.c"}
+	check(db, "app")
+}
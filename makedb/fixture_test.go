@@ -0,0 +1,108 @@
+package makedb
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestFixtures parses the output of "make --question --print-data-base"
+// that was captured from different versions of make, for the Makefile in
+// the testdata directory. This tests the parser without running make.
+//
+// To add a version, run testdata/capture.sh with the path to that make.
+// It copies the Makefile to a temporary directory, creates main.c, util.c,
+// README.md, CHANGES.md and a bin directory modified at 2021-03-14 09:00:00
+// along with the Makefile, and main.o and docs modified at 2021-03-14
+// 09:10:00. Then it runs make with an empty environment and saves the output
+// as testdata/gnu-make-<version>.db, replacing the directory with
+// /src/example.
+func TestFixtures(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "*.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("Expected fixtures in the testdata directory")
+	}
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			file, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			db := NewDatabase()
+			if err := db.Populate(file); err != nil {
+				t.Fatal(err)
+			}
+			testFixture(t, db)
+		})
+	}
+}
+
+func testFixture(t *testing.T, db Database) {
+	if db.DefaultGoal != "all" {
+		t.Errorf("Expected default goal all, got %q", db.DefaultGoal)
+	}
+
+	target := func(name string) *Target {
+		t.Helper()
+		target := db.Targets[name]
+		if target == nil {
+			t.Fatalf("Expected target %s", name)
+		}
+		return target
+	}
+
+	if all := target("all"); !all.Phony || strings.Join(all.NormalPrerequisites, " ") != "app docs" {
+		t.Errorf("Unexpected all target: %+v", all)
+	}
+
+	app := target("app")
+	if got := strings.Join(app.NormalPrerequisites, " "); got != "main.o util.o" {
+		t.Errorf("Expected app prerequisites main.o util.o, got %s", got)
+	}
+	if got := strings.Join(app.OrderOnlyPrerequisites, " "); got != "bin" {
+		t.Errorf("Expected app order-only prerequisites bin, got %s", got)
+	}
+	if !app.DoesNotExist || !app.NeedsUpdate || app.Phony {
+		t.Errorf("Expected app to be missing and need updating: %+v", app)
+	}
+
+	docs := target("docs")
+	if !docs.DoubleColon || strings.Join(docs.NormalPrerequisites, " ") != "README.md CHANGES.md" {
+		t.Errorf("Expected both docs rules to be merged: %+v", docs)
+	}
+
+	mainO := target("main.o")
+	modified := time.Date(2021, 3, 14, 9, 10, 0, 0, time.Local)
+	if !mainO.LastModified.Equal(modified) || mainO.NeedsUpdate || mainO.DoesNotExist {
+		t.Errorf("Expected main.o to be up to date, modified at %s: %+v", modified, mainO)
+	}
+	if util := target("util.o"); !util.DoesNotExist || !util.NeedsUpdate {
+		t.Errorf("Expected util.o to be missing: %+v", util)
+	}
+
+	if clean := db.Targets["clean"]; clean == nil || !clean.Phony {
+		t.Errorf("Expected clean to be phony: %+v", clean)
+	}
+	if suffix := db.Targets[".c.o"]; suffix != nil && !suffix.NotTarget {
+		t.Errorf("Expected built-in rules not to be targets: %+v", suffix)
+	}
+
+	// Make stops checking at util.o, the first prerequisite that needs
+	// updating, so that is where the pending targets end.
+	since := modified.Add(time.Hour)
+	if got := strings.Join(db.GetPendingTargetNames("", since), ","); got != "app,util.o" {
+		t.Errorf("Expected pending targets app,util.o, got %s", got)
+	}
+	if got := strings.Join(db.GetPendingTargetNames("app", since), ","); got != "app,util.o" {
+		t.Errorf("Expected pending targets app,util.o, got %s", got)
+	}
+	if count := db.GetPendingTargets("docs", since); count != 0 {
+		t.Errorf("Expected docs to be up to date, got %d pending targets", count)
+	}
+}
@@ -0,0 +1,80 @@
+package makedb
+
+import (
+	"errors"
+	"io"
+	"strings"
+)
+
+// A Flavor is an implementation of make. They print their databases
+// in different formats.
+type Flavor string
+
+const (
+	// GNU is GNU Make, which prints its database with --print-data-base.
+	GNU Flavor = "gnu"
+
+	// BSD is BSD make, such as bmake.
+	BSD Flavor = "bsd"
+)
+
+// ErrUnsupported is returned when parsing the database
+// of a flavor of make that is not supported yet.
+var ErrUnsupported = errors.New("this flavor of make is not supported yet")
+
+// A Parser populates a Database from the output of a make query.
+type Parser interface {
+	Populate(db *Database, r io.Reader) error
+}
+
+// NewParser returns the Parser for a flavor of make.
+// An empty flavor is treated as GNU Make.
+func NewParser(flavor Flavor) Parser {
+	if flavor == BSD {
+		return bsdParser{}
+	}
+	return gnuParser{}
+}
+
+// gnuParser reads the output of "make --print-data-base" from GNU Make.
+type gnuParser struct{}
+
+func (gnuParser) Populate(db *Database, r io.Reader) error {
+	// Stop the reader if this returns early because of an error.
+	cancel := make(chan struct{})
+	defer close(cancel)
+	ch, dch, mch, done := readTargets(r, cancel)
+	for {
+		select {
+		case name := <-dch:
+			db.DefaultGoal = name
+		case list := <-mch:
+			db.makefiles = strings.Fields(list)
+		case s := <-ch:
+			t := &Target{}
+			if err := t.Populate(s); err != nil {
+				return err
+			}
+			if len(t.Name) == 0 {
+				// This block of text was not a target.
+				continue
+			}
+			if prev := db.Targets[t.Name]; prev != nil {
+				prev.merge(t)
+				continue
+			}
+			db.Targets[t.Name] = t
+		case err := <-done:
+			return err
+		}
+	}
+}
+
+// bsdParser is a placeholder for BSD make. It has no equivalent of
+// --print-data-base; its variables and dependency graph can be printed
+// with debugging options, but that output is not parsed yet.
+type bsdParser struct{}
+
+func (bsdParser) Populate(db *Database, r io.Reader) error {
+	return ErrUnsupported
+}
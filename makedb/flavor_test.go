@@ -0,0 +1,75 @@
+package makedb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestNewParser(t *testing.T) {
+	for _, flavor := range []Flavor{GNU, ""} {
+		file, err := os.Open(filepath.Join("testdata", "gnu-make-4.3.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		db := NewDatabase()
+		err = NewParser(flavor).Populate(&db, file)
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if db.DefaultGoal != "all" || db.Targets["app"] == nil {
+			t.Errorf("Expected the %q parser to read the GNU Make database", flavor)
+		}
+	}
+
+	db := NewDatabase()
+	if err := NewParser(GNU).Populate(&db, strings.NewReader("make: *** No targets.  Stop.\n")); err != ErrNoFiles {
+		t.Errorf("Expected ErrNoFiles without a files section, got %v", err)
+	}
+
+	db = NewDatabase()
+	if err := NewParser(BSD).Populate(&db, strings.NewReader("")); err != ErrUnsupported {
+		t.Errorf("Expected ErrUnsupported for BSD make, got %v", err)
+	}
+}
+
+func TestGNUParserErrors(t *testing.T) {
+	// Lines longer than the bufio.Scanner default are fine.
+	long := "SOURCES := " + strings.Repeat("src/file.c ", 10000) + "\n"
+	db := NewDatabase()
+	if err := NewParser(GNU).Populate(&db, strings.NewReader(long+"# Files\n\nall: a\n")); err != nil {
+		t.Errorf("Expected a long line to be read, got %s", err)
+	} else if db.Targets["all"] == nil {
+		t.Error("Expected the all target after a long line")
+	}
+
+	// Errors from reading are returned rather than exiting.
+	readErr := errors.New("read failed")
+	db = NewDatabase()
+	if err := NewParser(GNU).Populate(&db, iotest.ErrReader(readErr)); err != readErr {
+		t.Errorf("Expected the read error, got %v", err)
+	}
+
+	// Returning early because of a bad block doesn't leave the reader
+	// goroutine blocked forever.
+	before := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		db = NewDatabase()
+		if err := NewParser(GNU).Populate(&db, strings.NewReader("# Files\n\nnot a rule\n\na: b\n\nb: c\n")); err == nil {
+			t.Fatal("Expected an error for a bad block")
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("Expected no leaked goroutines but got %d more", after-before)
+	}
+}
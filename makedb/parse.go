@@ -3,74 +3,126 @@ package makedb
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"io"
-	"log"
 )
 
+// ErrNoFiles is returned when the output of "make --print-data-base" has no
+// files section. Make prints nothing useful when the Makefile has errors,
+// or when it is not a version of make that supports that option.
+var ErrNoFiles = errors.New("no files section in the make database")
+
+// maxLineSize is the longest line that can be read from the make database.
+// Variables with long values, such as lists of source files, can be much
+// longer than the bufio.Scanner default of 64KB.
+const maxLineSize = 16 * 1024 * 1024
+
 var (
-	defaultGoal = []byte(".DEFAULT_GOAL := ")
+	defaultGoal  = []byte(".DEFAULT_GOAL := ")
+	makefileList = []byte("MAKEFILE_LIST := ")
 )
 
+// newScanner returns a line scanner that allows lines up to maxLineSize.
+func newScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxLineSize)
+	return scanner
+}
+
 // readTargets reads from "make --print-data-base" and returns a channel,
 // which is populated with blocks of text for each target it finds.
-func readTargets(r io.Reader) (ch, dch chan string, done chan struct{}) {
+// The default goal and the list of makefiles are sent to dch and mch.
+// After the last block of text, the result is sent to done: nil, an error
+// from reading r, or ErrNoFiles if there was no files section. Closing
+// cancel makes it stop early, so that it doesn't block forever.
+func readTargets(r io.Reader, cancel <-chan struct{}) (ch, dch, mch chan string, done chan error) {
 
 	ch = make(chan string)
 	dch = make(chan string)
-	done = make(chan struct{})
+	mch = make(chan string)
+	done = make(chan error)
+
+	send := func(c chan string, s string) bool {
+		select {
+		case c <- s:
+			return true
+		case <-cancel:
+			return false
+		}
+	}
 
 	go func() {
 		defer close(ch)
 		defer close(dch)
-		defer close(done)
+		defer close(mch)
 
-		scanner := bufio.NewScanner(r)
+		err := func() error {
+			scanner := newScanner(r)
 
-		// Skip ahead to the files section.
-		filesHeader := []byte("# Files")
-		filesSection := false
-		for scanner.Scan() {
-			line := scanner.Bytes()
-			if bytes.HasPrefix(line, defaultGoal) {
-				defaultGoalName := string(line[len(defaultGoal):])
-				dch <- defaultGoalName
-			} else if bytes.Equal(line, filesHeader) {
-				filesSection = true
-				break
+			// Skip ahead to the files section.
+			filesHeader := []byte("# Files")
+			filesSection := false
+			for scanner.Scan() {
+				line := scanner.Bytes()
+				if bytes.HasPrefix(line, defaultGoal) {
+					if !send(dch, string(line[len(defaultGoal):])) {
+						return nil
+					}
+				} else if bytes.HasPrefix(line, makefileList) {
+					if !send(mch, string(line[len(makefileList):])) {
+						return nil
+					}
+				} else if bytes.Equal(line, filesHeader) {
+					filesSection = true
+					break
+				}
+			}
+			if err := scanner.Err(); err != nil {
+				return err
+			}
+			if !filesSection {
+				return ErrNoFiles
 			}
-		}
-		if err := scanner.Err(); err != nil {
-			log.Fatal(err)
-		}
-		if !filesSection {
-			return
-		}
 
-		// Now read each block of text and put them on the channel.
-		// Blocks of text are separated by blank links.
-		buf := new(bytes.Buffer)
-		newline := []byte("\n")
-		for scanner.Scan() {
-			line := scanner.Bytes()
-			if len(line) == 0 {
-				if buf.Len() != 0 {
-					ch <- buf.String()
-					buf = new(bytes.Buffer)
+			// Now read each block of text and put them on the channel.
+			// Blocks of text are separated by blank links.
+			buf := new(bytes.Buffer)
+			newline := []byte("\n")
+			for scanner.Scan() {
+				line := scanner.Bytes()
+				sent := true
+				if bytes.HasPrefix(line, defaultGoal) {
+					// Some versions of Make print variables after the files
+					// section, so the default goal can be found here too.
+					sent = send(dch, string(line[len(defaultGoal):]))
+				} else if bytes.HasPrefix(line, makefileList) {
+					sent = send(mch, string(line[len(makefileList):]))
+				} else if len(line) == 0 {
+					if buf.Len() != 0 {
+						sent = send(ch, buf.String())
+						buf = new(bytes.Buffer)
+					}
+				} else {
+					buf.Write(line)
+					buf.Write(newline)
+				}
+				if !sent {
+					return nil
 				}
-			} else {
-				buf.Write(line)
-				buf.Write(newline)
 			}
-		}
-		if err := scanner.Err(); err != nil {
-			log.Fatal(err)
-		}
-		if buf.Len() != 0 {
-			ch <- buf.String()
-		}
-
-		done <- struct{}{}
+			if err := scanner.Err(); err != nil {
+				return err
+			}
+			if buf.Len() != 0 {
+				send(ch, buf.String())
+			}
+			return nil
+		}()
 
+		select {
+		case done <- err:
+		case <-cancel:
+		}
 	}()
 
 	return
@@ -0,0 +1,76 @@
+package makedb
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Makefiles returns the names of the makefiles that Make read, including
+// any included makefiles, from the MAKEFILE_LIST variable.
+func (db *Database) Makefiles() []string {
+	return db.makefiles
+}
+
+// Restat updates a target and its dependencies with the current state of
+// their files, so that the database can be reused without running Make again.
+// File names are relative to dir. Whether targets need updating is then worked
+// out from the modification times of their prerequisites, like Make does. It
+// returns false if the database is incomplete, because Make stopped checking
+// before it got to some prerequisites, in which case Make needs to run again.
+func (db *Database) Restat(dir string, target string) (complete bool, err error) {
+	t := db.GetTarget(target)
+	nDeps, oDeps := db.GetDeps(t.Name)
+	names := append([]string{t.Name}, nDeps...)
+	names = append(names, oDeps...)
+	for _, name := range names {
+		if _, found := db.Targets[name]; !found {
+			return false, nil
+		}
+	}
+	for _, name := range names {
+		t := db.Targets[name]
+		if t.Phony {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(dir, t.Name))
+		if os.IsNotExist(err) {
+			t.DoesNotExist = true
+			t.LastModified = time.Time{}
+			continue
+		} else if err != nil {
+			return false, err
+		}
+		t.DoesNotExist = false
+		t.LastModified = info.ModTime()
+	}
+	db.restatNeedsUpdate(t.Name, map[string]bool{})
+	return true, nil
+}
+
+// restatNeedsUpdate works out whether a target needs updating, after its
+// prerequisites. The checked map keeps track of targets that have been done,
+// which also stops dependency cycles from looping forever.
+func (db *Database) restatNeedsUpdate(name string, checked map[string]bool) bool {
+	t := db.Targets[name]
+	if checked[name] {
+		return t.NeedsUpdate
+	}
+	checked[name] = true
+	t.NeedsUpdate = false
+	t.NewerPrerequisites = nil
+	for _, dep := range t.NormalPrerequisites {
+		changed := db.restatNeedsUpdate(dep, checked)
+		p := db.Targets[dep]
+		if changed || p.Phony || p.DoesNotExist || p.LastModified.After(t.LastModified) {
+			t.NeedsUpdate = !t.Phony
+		}
+	}
+	for _, dep := range t.OrderOnlyPrerequisites {
+		changed := db.restatNeedsUpdate(dep, checked)
+		if p := db.Targets[dep]; changed || p.DoesNotExist {
+			t.NeedsUpdate = !t.Phony
+		}
+	}
+	return t.NeedsUpdate
+}
@@ -0,0 +1,74 @@
+package makedb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRestat(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	touch := func(name string, mtime time.Time) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	touch("src", now.Add(-time.Hour))
+	touch("out", now)
+
+	db := &Database{
+		DefaultGoal: "all",
+		Targets: map[string]*Target{
+			"all":  {Name: "all", Phony: true, NormalPrerequisites: []string{"out"}, OrderOnlyPrerequisites: []string{"logs"}},
+			"out":  {Name: "out", NormalPrerequisites: []string{"src"}},
+			"src":  {Name: "src", NotTarget: true},
+			"logs": {Name: "logs"},
+		},
+	}
+	pending := func() string {
+		t.Helper()
+		complete, err := db.Restat(dir, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !complete {
+			t.Fatal("Expected a complete database")
+		}
+		return strings.Join(db.GetPendingTargetNames("", now.Add(time.Hour)), ",")
+	}
+
+	// The order-only logs directory is missing.
+	if got := pending(); got != "logs" {
+		t.Errorf("Expected logs got %q", got)
+	}
+
+	// Changing the source makes out need updating.
+	touch("logs", now)
+	touch("src", now.Add(time.Minute))
+	if got := pending(); got != "out" {
+		t.Errorf("Expected out got %q", got)
+	}
+	if !db.Targets["out"].NeedsUpdate {
+		t.Error("Expected out to need updating")
+	}
+
+	touch("out", now.Add(2*time.Minute))
+	if got := pending(); got != "" {
+		t.Errorf("Expected nothing pending got %q", got)
+	}
+
+	// Prerequisites that Make didn't get to make the database incomplete.
+	db.Targets["out"].NormalPrerequisites = []string{"src", "unknown"}
+	if complete, err := db.Restat(dir, ""); err != nil || complete {
+		t.Errorf("Expected an incomplete database, got %t %v", complete, err)
+	}
+}
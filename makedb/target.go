@@ -1,8 +1,6 @@
 package makedb
 
 import (
-	"bufio"
-	"bytes"
 	"fmt"
 	"regexp"
 	"strings"
@@ -10,17 +8,27 @@ import (
 )
 
 var (
-	doesNotExist       = regexp.MustCompile(`#\s+File does not exist\.`)
-	lastModified       = regexp.MustCompile(`#\s+Last modified\s+(.+)`)
-	lastModifiedFormat = "2006-01-02 15:04:05"
-	needsUpdate        = regexp.MustCompile(`#\s+Needs to be updated \(-q is set\)\.`)
-	notTarget          = regexp.MustCompile(`#\s+Not a target:`)
-	phonyTarget        = regexp.MustCompile(`#\s+Phony target \(prerequisite of \.PHONY\)\.`)
+	doesNotExist      = regexp.MustCompile(`#\s+File does not exist\.`)
+	lastModified      = regexp.MustCompile(`#\s+Last modified\s+(.+)`)
+	needsUpdate       = regexp.MustCompile(`#\s+Needs to be updated \(-q is set\)\.`)
+	newerPrerequisite = regexp.MustCompile(`#\s+Prerequisite '(.+)' is newer than target '.+'\.`)
+	notTarget         = regexp.MustCompile(`#\s+Not a target:`)
+	phonyTarget       = regexp.MustCompile(`#\s+Phony target \(prerequisite of \.PHONY\)\.`)
 )
 
+// lastModifiedLayouts are the formats that different versions of Make use
+// for "Last modified" times. Newer versions include fractional seconds,
+// and builds without high resolution timestamps use the ctime format.
+var lastModifiedLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04:05.999999999",
+	"Mon Jan _2 15:04:05 2006",
+}
+
 // A Target represents a Makefile target.
 type Target struct {
 	Name                   string
+	DoubleColon            bool
 	NormalPrerequisites    []string
 	OrderOnlyPrerequisites []string
 	NotTarget              bool
@@ -28,69 +36,173 @@ type Target struct {
 	NeedsUpdate            bool
 	DoesNotExist           bool
 	LastModified           time.Time
+	NewerPrerequisites     []string
+	Unknown                bool
+	Recipe                 []string
 }
 
 // PopulateNames populates the name and prerequisites from a line of text.
+// Order-only prerequisites come after a "|", which can be a word by itself
+// or attached to the first order-only prerequisite.
 func (t *Target) PopulateNames(line []byte) error {
 
-	r := bytes.NewReader(line)
-	s := bufio.NewScanner(r)
-	s.Split(bufio.ScanWords)
+	name, prerequisites, doubleColon, found := splitRule(string(line))
+	if !found || len(name) == 0 {
+		return fmt.Errorf("unable to parse line: %s", line)
+	}
+	t.Name = name
+	t.DoubleColon = doubleColon
 
 	orderOnlyMode := false
 
-	for s.Scan() {
-		word := string(s.Bytes())
-		if len(t.Name) == 0 {
-			t.Name = word[:len(word)-1]
-		} else if word[0] == '|' {
+	for _, word := range splitWords(prerequisites) {
+		if word[0] == '|' {
 			orderOnlyMode = true
+			if len(word) > 1 {
+				t.OrderOnlyPrerequisites = append(t.OrderOnlyPrerequisites, word[1:])
+			}
 		} else if orderOnlyMode {
 			t.OrderOnlyPrerequisites = append(t.OrderOnlyPrerequisites, word)
 		} else {
 			t.NormalPrerequisites = append(t.NormalPrerequisites, word)
 		}
 	}
-	if err := s.Err(); err != nil {
-		return err
+
+	return nil
+}
+
+// nameEscapes removes the backslashes from escaped spaces and colons.
+var nameEscapes = strings.NewReplacer(`\ `, " ", `\:`, ":")
+
+// splitRule splits a rule's line into the target name and prerequisites.
+// Names can contain colons, as in "C:/file.o" or "http://host", so the
+// separator is the first unescaped colon followed by whitespace, the end of
+// the line, or another colon for double-colon rules.
+func splitRule(line string) (name, prerequisites string, doubleColon, found bool) {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			// Skip the escaped character.
+			i++
+		case ':':
+			if i+1 == len(line) || strings.ContainsRune(" \t:", rune(line[i+1])) {
+				prerequisites = line[i+1:]
+				if strings.HasPrefix(prerequisites, ":") {
+					prerequisites = prerequisites[1:]
+					doubleColon = true
+				}
+				return nameEscapes.Replace(strings.TrimSpace(line[:i])), prerequisites, doubleColon, true
+			}
+		}
 	}
+	return "", "", false, false
+}
 
-	if len(t.Name) == 0 {
-		return fmt.Errorf("unable to parse line: %s", line)
+// splitWords splits a line into words separated by whitespace. Spaces and
+// colons escaped with a backslash, as in "a\ b.o", are part of the word.
+func splitWords(line string) (words []string) {
+	var word strings.Builder
+	inWord := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && i+1 < len(line) && (line[i+1] == ' ' || line[i+1] == ':'):
+			word.WriteByte(line[i+1])
+			inWord = true
+			i++
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
 	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words
+}
 
-	return nil
+// merge adds the information from another block of text for the same
+// target. Make can print more than one block for a target, such as for
+// double-colon rules, which have a block for each rule with their own
+// prerequisites and recipes. The prerequisites are combined without
+// duplicates, and the target needs updating if either block says so.
+func (t *Target) merge(other *Target) {
+	t.DoubleColon = t.DoubleColon || other.DoubleColon
+	t.NormalPrerequisites = appendUnique(t.NormalPrerequisites, other.NormalPrerequisites...)
+	t.OrderOnlyPrerequisites = appendUnique(t.OrderOnlyPrerequisites, other.OrderOnlyPrerequisites...)
+	t.NewerPrerequisites = appendUnique(t.NewerPrerequisites, other.NewerPrerequisites...)
+	if t.DoubleColon || len(t.Recipe) == 0 {
+		t.Recipe = append(t.Recipe, other.Recipe...)
+	}
+	t.NotTarget = t.NotTarget && other.NotTarget
+	t.Phony = t.Phony || other.Phony
+	t.NeedsUpdate = t.NeedsUpdate || other.NeedsUpdate
+	t.DoesNotExist = t.DoesNotExist || other.DoesNotExist
+	if other.LastModified.After(t.LastModified) {
+		t.LastModified = other.LastModified
+	}
+}
+
+// appendUnique appends the items that are not already in the list.
+func appendUnique(list []string, items ...string) []string {
+	for _, item := range items {
+		found := false
+		for _, existing := range list {
+			if existing == item {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, item)
+		}
+	}
+	return list
 }
 
 // Populate the target from r, which should contain one
 // target's block of text from "make --print-data-base".
 func (t *Target) Populate(s string) error {
-	scanner := bufio.NewScanner(strings.NewReader(s))
+	scanner := newScanner(strings.NewReader(s))
 	for scanner.Scan() {
 		line := scanner.Bytes()
 		if notTarget.Match(line) {
 			t.NotTarget = true
 		} else if len(t.Name) == 0 {
+			if len(line) != 0 && line[0] == '#' {
+				// Comments before the name, or blocks such as the
+				// "# VPATH Search Paths" section, which are not targets.
+				continue
+			}
 			if err := t.PopulateNames(line); err != nil {
 				return err
 			}
+		} else if len(line) != 0 && line[0] == '\t' {
+			// Recipe lines are indented with a tab. They come after a
+			// "recipe to execute (from 'Makefile', line N):" comment,
+			// or "commands to execute" in older versions of Make,
+			// which gets skipped along with other unknown comments.
+			t.Recipe = append(t.Recipe, string(line[1:]))
 		} else if phonyTarget.Match(line) {
 			t.Phony = true
 		} else if needsUpdate.Match(line) {
 			t.NeedsUpdate = true
 		} else if doesNotExist.Match(line) {
 			t.DoesNotExist = true
+		} else if matches := newerPrerequisite.FindSubmatch(line); matches != nil {
+			t.NewerPrerequisites = append(t.NewerPrerequisites, string(matches[1]))
 		} else if matches := lastModified.FindSubmatch(line); matches != nil {
-			s := string(matches[1])
-			if s == "1970-01-01 00:59:56" {
-				panic(fmt.Sprintf("%s: %s", t.String(), line))
-			} else {
-				lastModified, err := time.ParseInLocation(lastModifiedFormat, s, time.Local)
-				if err != nil {
-					return err
-				}
-				t.LastModified = lastModified
+			lastModified, err := parseLastModified(string(matches[1]))
+			if err != nil {
+				return fmt.Errorf("%s: %w", t.Name, err)
 			}
+			t.LastModified = lastModified
 		}
 	}
 	if err := scanner.Err(); err != nil {
@@ -99,6 +211,18 @@ func (t *Target) Populate(s string) error {
 	return nil
 }
 
+// parseLastModified parses a "Last modified" time using
+// the first of lastModifiedLayouts that matches.
+func parseLastModified(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range lastModifiedLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unable to parse last modified time: %s", s)
+}
+
 func (t *Target) String() string {
 	status := "ok"
 	if t.Phony {
@@ -107,6 +231,8 @@ func (t *Target) String() string {
 		status = "missing"
 	} else if t.NeedsUpdate {
 		status = "needs update"
+	} else if t.Unknown {
+		status = "unknown"
 	}
 	return fmt.Sprintf("%s (%s)", t.Name, status)
 }
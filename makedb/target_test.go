@@ -0,0 +1,144 @@
+package makedb
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTargetNewerPrerequisites(t *testing.T) {
+	target := &Target{}
+	err := target.Populate(`f1: f2 f3
+#  Implicit rule search has not been done.
+#  Prerequisite 'f2' is newer than target 'f1'.
+#  Prerequisite 'f3' is newer than target 'f1'.
+#  Last modified 2021-03-14 09:12:33
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(target.NewerPrerequisites, ",")
+	if got != "f2,f3" {
+		t.Errorf("Expected f2,f3 got %s", got)
+	}
+
+	// Make reporting newer prerequisites is enough to need an update.
+	db := NewDatabase()
+	db.Targets[target.Name] = target
+	db.Targets["f2"] = &Target{Name: "f2"}
+	db.Targets["f3"] = &Target{Name: "f3"}
+	if count := db.GetPendingTargets("f1", target.LastModified); count != 1 {
+		t.Errorf("Expected 1 pending target, got %d", count)
+	}
+}
+
+func TestTargetRecipe(t *testing.T) {
+	target := &Target{}
+	err := target.Populate("all: a b\n" +
+		"#  Implicit rule search has not been done.\n" +
+		"#  Modification time never checked.\n" +
+		"#  File has not been updated.\n" +
+		"#  recipe to execute (from 'Makefile', line 3):\n" +
+		"\t@echo hi\n" +
+		"\ttouch all\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(target.Recipe, ",")
+	if got != "@echo hi,touch all" {
+		t.Errorf("Expected @echo hi,touch all got %s", got)
+	}
+}
+
+func TestTargetLastModifiedLayouts(t *testing.T) {
+	for s, want := range map[string]time.Time{
+		"2021-03-14 09:12:33":           time.Date(2021, 3, 14, 9, 12, 33, 0, time.Local),
+		"2021-03-14 09:12:33.123456789": time.Date(2021, 3, 14, 9, 12, 33, 123456789, time.Local),
+		"Sun Mar 14 09:12:33 2021":      time.Date(2021, 3, 14, 9, 12, 33, 0, time.Local),
+		"Tue Mar  2 09:12:33 2021":      time.Date(2021, 3, 2, 9, 12, 33, 0, time.Local),
+	} {
+		target := &Target{}
+		if err := target.Populate("f1:\n#  Last modified " + s + "\n"); err != nil {
+			t.Fatal(err)
+		}
+		if !target.LastModified.Equal(want) {
+			t.Errorf("Expected %s got %s", want, target.LastModified)
+		}
+	}
+
+	target := &Target{}
+	if err := target.Populate("f1:\n#  Last modified yesterday\n"); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
+
+func TestTargetEpochLastModified(t *testing.T) {
+	// Files extracted from some archives have times near the epoch.
+	target := &Target{}
+	if err := target.Populate("f1:\n#  Last modified 1970-01-01 00:59:56\n"); err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(1970, 1, 1, 0, 59, 56, 0, time.Local)
+	if !target.LastModified.Equal(want) {
+		t.Errorf("Expected %s got %s", want, target.LastModified)
+	}
+}
+
+func TestTargetPopulateNames(t *testing.T) {
+	for line, want := range map[string]string{
+		`all: a b`:                     "all: a,b | ",
+		`obj/a\ b.o: src/a\ b.c | dir`: "obj/a b.o: src/a b.c | dir",
+		`out: in |dir logs`:            "out: in | dir,logs",
+		"tabs:\ta\t| b":                "tabs: a | b",
+		"C:/obj/file.o: C:/src/file.c": "C:/obj/file.o: C:/src/file.c | ",
+		`http\://example: x`:           "http://example: x | ",
+		"c:d: x":                       "c:d: x | ",
+		"double:: x":                   "double: x | ",
+		"empty:":                       "empty:  | ",
+		"obj/a b.o: src":               "obj/a b.o: src | ",
+	} {
+		target := &Target{}
+		if err := target.PopulateNames([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+		got := target.Name + ": " + strings.Join(target.NormalPrerequisites, ",") + " | " + strings.Join(target.OrderOnlyPrerequisites, ",")
+		if got != want {
+			t.Errorf("Parsing %q, expected %q, got %q", line, want, got)
+		}
+	}
+}
+
+func TestTargetPopulateNamesError(t *testing.T) {
+	target := &Target{}
+	if err := target.PopulateNames([]byte("no separator")); err == nil {
+		t.Error("Expected an error")
+	}
+}
+
+func TestTargetMerge(t *testing.T) {
+	before := time.Date(2021, 3, 14, 9, 0, 0, 0, time.Local)
+	after := before.Add(time.Hour)
+	out := "# Files\n" +
+		"\n" +
+		"out: a b | dir\n" +
+		"#  Last modified 2021-03-14 10:00:00\n" +
+		"\n" +
+		"out: b c\n" +
+		"#  Needs to be updated (-q is set).\n" +
+		"#  Last modified 2021-03-14 09:00:00\n"
+	db := NewDatabase()
+	if err := db.Populate(strings.NewReader(out)); err != nil {
+		t.Fatal(err)
+	}
+	target := db.Targets["out"]
+	got := strings.Join(target.NormalPrerequisites, ",") + " | " + strings.Join(target.OrderOnlyPrerequisites, ",")
+	if got != "a,b,c | dir" {
+		t.Errorf("Expected a,b,c | dir got %s", got)
+	}
+	if !target.NeedsUpdate {
+		t.Error("Expected the target to need updating")
+	}
+	if !target.LastModified.Equal(after) {
+		t.Errorf("Expected the latest modification time, got %s", target.LastModified)
+	}
+}
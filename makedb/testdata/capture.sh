@@ -0,0 +1,32 @@
+#!/bin/sh
+# Captures a fixture for TestFixtures from a version of make, as described
+# in fixture_test.go. Run it from the testdata directory:
+#
+#     ./capture.sh /path/to/make
+#
+# It writes gnu-make-<version>.db next to this script.
+set -eu
+
+MAKE=${1:-make}
+VERSION=$("$MAKE" --version | sed -n 's/^GNU Make \([0-9][0-9.]*\).*/\1/p')
+if [ -z "$VERSION" ]; then
+    echo "$MAKE is not GNU Make" >&2
+    exit 1
+fi
+
+HERE=$(cd "$(dirname "$0")" && pwd)
+WORK=$(mktemp -d)
+OUT=$(mktemp)
+trap 'rm -rf "$WORK" "$OUT"' EXIT
+
+cp "$HERE/Makefile" "$WORK/"
+cd "$WORK"
+touch -t 202103140900.00 Makefile main.c util.c README.md CHANGES.md
+mkdir bin
+touch -t 202103140900.00 bin
+touch -t 202103140910.00 main.o docs
+
+# make exits with 1 because the targets are not up to date.
+env -i PATH=/usr/bin:/bin "$MAKE" --question --print-data-base > "$OUT" 2>&1 || true
+sed "s|$WORK|/src/example|g" "$OUT" > "$HERE/gnu-make-$VERSION.db"
+echo "Wrote gnu-make-$VERSION.db"
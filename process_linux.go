@@ -0,0 +1,47 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// getProcessName gets the base name of a process. It reads /proc, which is
+// faster than running ps and works without it. If /proc can't be read,
+// then it falls back to ps.
+func getProcessName(pid int) (name string, err error) {
+	b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	if err != nil {
+		return psProcessName(pid)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// getParentID gets the parent ID of a process, from /proc or else ps.
+func getParentID(pid int) (ppid int, err error) {
+	b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return psParentID(pid)
+	}
+	return parseStatParentID(string(b))
+}
+
+// parseStatParentID returns the parent ID from the contents of a
+// /proc/<pid>/stat file. The second field is the command name in brackets,
+// which can contain spaces and brackets, so the fields are counted from
+// after the last closing bracket. The parent ID is the 4th field.
+func parseStatParentID(stat string) (ppid int, err error) {
+	i := strings.LastIndex(stat, ")")
+	if i < 0 {
+		return 0, fmt.Errorf("unexpected stat format: %q", stat)
+	}
+	fields := strings.Fields(stat[i+1:])
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("unexpected stat format: %q", stat)
+	}
+	return strconv.Atoi(fields[1])
+}
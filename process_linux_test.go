@@ -0,0 +1,32 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParseStatParentID(t *testing.T) {
+	ppid, err := parseStatParentID("123 (my (odd) cmd) S 45 123 123 0 -1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ppid != 45 {
+		t.Errorf("Expected parent ID 45, got %d", ppid)
+	}
+	if _, err := parseStatParentID("garbage"); err == nil {
+		t.Error("Expected an error")
+	}
+}
+
+func TestGetParentID(t *testing.T) {
+	ppid, err := getParentID(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ppid != os.Getppid() {
+		t.Errorf("Expected parent ID %d, got %d", os.Getppid(), ppid)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// getProcessName gets the base name of a process.
+func getProcessName(pid int) (name string, err error) {
+	return psProcessName(pid)
+}
+
+// getParentID gets the parent ID of a process.
+func getParentID(pid int) (ppid int, err error) {
+	return psParentID(pid)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// psProcessName gets the base name of a process using ps.
+func psProcessName(pid int) (name string, err error) {
+	p := fmt.Sprintf("%d", pid)
+	cmd := exec.Command("ps", "-p", p, "-o", "comm=")
+	out, err := cmd.Output()
+	if err != nil {
+		return name, err
+	}
+	name = filepath.Base(strings.TrimSpace(string(out)))
+	return name, nil
+}
+
+// psParentID gets the parent ID of a process using ps.
+func psParentID(pid int) (ppid int, err error) {
+	spid := fmt.Sprintf("%d", pid)
+	out, err := exec.Command("ps", "-p", spid, "-o", "ppid=").Output()
+	if err != nil {
+		return ppid, err
+	}
+	ppid, err = strconv.Atoi(strings.TrimSpace(string(out)))
+	return ppid, err
+}
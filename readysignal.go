@@ -2,15 +2,52 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
-	"os/exec"
 	"os/signal"
-	"path/filepath"
-	"strconv"
 	"strings"
 	"syscall"
 )
 
+// readySignalEnv is the environment variable that tells "remake -ready"
+// which signal to send. Remake sets it for its make commands.
+const readySignalEnv = "REMAKE_READY_SIGNAL"
+
+const (
+	// goalEnvName is the environment variable with the target
+	// that a make command is building.
+	goalEnvName = "REMAKE_GOAL"
+
+	// readyFileEnv is the environment variable with the file that
+	// "remake -ready" creates before sending the ready signal, when
+	// Remake is managing multiple goals. It tells Remake which goal
+	// the signal is for.
+	readyFileEnv = "REMAKE_READY_FILE"
+)
+
+var (
+	// readyDir is the temporary directory containing the readyFiles.
+	readyDir string
+
+	// readyFiles are the files for each goal's ready signal.
+	readyFiles = map[goal]string{}
+)
+
+// readySignals are the signals that can be used as the ready signal.
+var readySignals = map[string]syscall.Signal{
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+// parseReadySignal returns the signal for a name such as "USR2" or "SIGUSR2".
+func parseReadySignal(name string) (syscall.Signal, error) {
+	sig, found := readySignals[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	if !found {
+		return 0, fmt.Errorf("unsupported signal %q, must be USR1 or USR2", name)
+	}
+	return sig, nil
+}
+
 // SignalListener has channels and methods required to watch signals.
 type SignalListener struct {
 	recv chan os.Signal
@@ -59,14 +96,14 @@ func (l *SignalListener) Stop() {
 
 // ReceiveReadySignal listens for "ready" signals,
 // and returns a channel for receiving them.
-func ReceiveReadySignal() chan os.Signal {
+func ReceiveReadySignal(sig os.Signal) chan os.Signal {
 	l := NewSignalListener()
-	return l.Listen(syscall.SIGUSR1)
+	return l.Listen(sig)
 }
 
 // SendReadySignal tries to send a "ready" signal
 // to the ancestor Remake process, if there is one.
-func SendReadySignal() (err error) {
+func SendReadySignal(sig os.Signal) (err error) {
 	processID := os.Getpid()
 	processName, err := getProcessName(processID)
 	if err != nil {
@@ -87,46 +124,29 @@ func SendReadySignal() (err error) {
 		if name == processName {
 			break
 		}
-		parentID, err = getParentID(parentID)
+		ppid, err := getParentID(parentID)
 		if err != nil {
-			return fmt.Errorf("getParentID: %d", parentID)
+			return fmt.Errorf("getParentID %d: %s", parentID, err)
 		}
+		parentID = ppid
 	}
 
 	// The ancestor process has been found, so it can be signaled. That lets
 	// it know that the dependencies have been built, and it can proceed past
 	// the init stage and start monitoring for changes.
+	if file := os.Getenv(readyFileEnv); len(file) != 0 {
+		if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+			return err
+		}
+	}
 	p, err := os.FindProcess(parentID)
 	if err != nil {
 		return err
 	}
 
-	if err := p.Signal(syscall.SIGUSR1); err != nil {
+	if err := p.Signal(sig); err != nil {
 		return fmt.Errorf("p.Signal: %s", err)
 	}
 
 	return nil
 }
-
-// getProcessName gets the base name of a process.
-func getProcessName(pid int) (name string, err error) {
-	p := fmt.Sprintf("%d", pid)
-	cmd := exec.Command("ps", "-p", p, "-o", "comm=")
-	out, err := cmd.Output()
-	if err != nil {
-		return name, err
-	}
-	name = filepath.Base(string(out))
-	return name, nil
-}
-
-// getParentID gets the parent ID of a process.
-func getParentID(pid int) (ppid int, err error) {
-	spid := fmt.Sprintf("%d", pid)
-	out, err := exec.Command("ps", "-p", spid, "-o", "ppid=").Output()
-	if err != nil {
-		return ppid, err
-	}
-	ppid, err = strconv.Atoi(strings.TrimSpace(string(out)))
-	return ppid, err
-}
@@ -0,0 +1,75 @@
+package runner
+
+import (
+	"time"
+
+	"github.com/raymondbutcher/remake/makecmd"
+)
+
+// coalesceWindow is how long to wait for other goals to need building,
+// so that they can be built together, with the Coalesce option.
+const coalesceWindow = 500 * time.Millisecond
+
+// buildBatch is a set of goals that get built by one make command.
+type buildBatch struct {
+	goals []Goal
+	built chan struct{}
+}
+
+// joinBatch adds a goal to the batch of goals waiting to be built in its
+// directory. If there is no batch, then it starts one and waits for other
+// goals to join it.
+// That goal leads the batch, and it must close the built channel after
+// its make command has built all of the goals, or exited. The other goals
+// should wait for that, rather than running their own make commands.
+func (r *runner) joinBatch(g Goal) (batch *buildBatch, leader bool) {
+	r.batchMutex.Lock()
+	if batch = r.batches[g.Dir]; batch != nil {
+		batch.goals = append(batch.goals, g)
+		r.batchMutex.Unlock()
+		return batch, false
+	}
+	batch = &buildBatch{
+		goals: []Goal{g},
+		built: make(chan struct{}),
+	}
+	r.batches[g.Dir] = batch
+	r.batchMutex.Unlock()
+
+	time.Sleep(coalesceWindow)
+
+	r.batchMutex.Lock()
+	delete(r.batches, g.Dir)
+	r.batchMutex.Unlock()
+	return batch, true
+}
+
+// waitForBatch waits for the other goals of a batch to be up to date, after
+// the leader's make command has left grace mode. That only means that the
+// leader's target is up to date, and the same command could still be
+// building the others. It stops waiting if the command exits.
+func (r *runner) waitForBatch(cmd *makecmd.Cmd, opts makecmd.Options, trigger string, check <-chan struct{}) {
+	if len(opts.ExtraGoals) == 0 {
+		return
+	}
+	others := []*makecmd.Cmd{}
+	for _, target := range opts.ExtraGoals {
+		g := Goal{Target: target, Dir: opts.Dir}
+		others = append(others, makecmd.NewCmd(target, r.options(g, trigger)))
+	}
+	for cmd.IsRunning() {
+		upToDate := true
+		for _, other := range others {
+			if err := other.UpdateProgress(); err != nil || other.CheckProgress() != 0 {
+				upToDate = false
+				break
+			}
+		}
+		if upToDate {
+			return
+		}
+		if _, ok := <-check; !ok {
+			return
+		}
+	}
+}
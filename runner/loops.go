@@ -0,0 +1,162 @@
+package runner
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/raymondbutcher/remake/logs"
+	"github.com/raymondbutcher/remake/makecmd"
+)
+
+// remake runs the main loop for one goal in Watch mode.
+// It only returns after stopping.
+func (r *runner) remake(g Goal) {
+	var cmd *makecmd.Cmd
+	check, stop := r.makeCheckChannel()
+	defer stop()
+	limiter := restartLimiter{max: r.cfg.MaxRestarts}
+	trigger := "start"
+	for {
+		if trigger != "start" {
+			if r.cfg.OnRestart != nil {
+				r.cfg.OnRestart(g)
+			}
+			if delay := limiter.delay(time.Now()); delay > 0 {
+				logs.Warnf(g.Target, "%s restarted more than %d times in %s, waiting %s", g, r.cfg.MaxRestarts, restartWindow, delay)
+				if !r.sleep(delay) {
+					return
+				}
+			}
+		}
+
+		opts := r.options(g, trigger)
+
+		// Build this target together with any other goals that need
+		// building at the same time, or let one of them build it.
+		// The default goal has no name, so it can't be combined.
+		grace := r.gracePeriod(g)
+		var built chan struct{}
+		if r.cfg.Coalesce && len(g.Target) != 0 {
+			batch, leader := r.joinBatch(g)
+			if !leader {
+				<-batch.built
+				r.follow(g, trigger, check)
+				if r.isStopped() {
+					return
+				}
+				trigger = "change"
+				continue
+			}
+			opts = r.batchOptions(batch.goals, trigger)
+			built = batch.built
+			for _, other := range batch.goals {
+				if d := r.gracePeriod(other); d > grace {
+					grace = d
+				}
+			}
+		}
+
+		// Create the make command for this target.
+		cmd = makecmd.NewCmd(g.Target, opts)
+		if !r.setCommand(g, cmd) {
+			if built != nil {
+				close(built)
+			}
+			return
+		}
+
+		// Start the command in grace mode. It won't return until
+		// it leaves grace mode and it is time for monitoring.
+		err := cmd.StartGraceMode(grace, r.cfg.Ready[g], check)
+		if built != nil {
+			if err == nil {
+				r.waitForBatch(cmd, opts, trigger, check)
+			}
+			close(built)
+		}
+		if r.isStopped() {
+			return
+		}
+		if err != nil {
+			logs.Errorf(g.Target, "%s", err)
+			if !r.sleep(errorSleep) {
+				return
+			}
+			trigger = "retry"
+		} else {
+			// And now monitor for changes. It won't return
+			// until the make command needs to be restarted.
+			cmd.MonitorMode(check)
+			if r.isStopped() {
+				return
+			}
+			trigger = "change"
+		}
+
+	}
+}
+
+// remakeOnce runs the make command for a goal until the target is up to
+// date, or until grace mode fails, in Once mode. If the command is still
+// running after that, then it is stopped.
+func (r *runner) remakeOnce(g Goal) {
+	check, stop := r.makeCheckChannel()
+	defer stop()
+	cmd := makecmd.NewCmd(g.Target, r.options(g, "start"))
+	if !r.setCommand(g, cmd) {
+		return
+	}
+	if err := cmd.StartGraceMode(r.gracePeriod(g), r.cfg.Ready[g], check); err != nil {
+		if err == makecmd.ErrStopped {
+			return
+		}
+		logs.Errorf(g.Target, "%s", err)
+		r.setStatus(g, err)
+		return
+	}
+	cmd.Stop()
+	if !r.getStatus(g).Finished {
+		// The command was killed after the target was up to date,
+		// which is all that is needed here.
+		r.setStatus(g, nil)
+	}
+}
+
+// follow monitors a target that was built by another goal's make command,
+// with the Coalesce option. It returns when the target has changed.
+func (r *runner) follow(g Goal, trigger string, check <-chan struct{}) {
+	cmd := makecmd.NewCmd(g.Target, r.options(g, trigger))
+	if err := cmd.UpdateProgress(); err != nil {
+		logs.Errorf(g.Target, "%s", err)
+	}
+	cmd.MonitorMode(check)
+}
+
+// detect runs the main loop for DetectOnly mode. It reports each time the
+// target changes, but never builds it. It only returns after stopping.
+func (r *runner) detect(g Goal) {
+	check, stop := r.makeCheckChannel()
+	defer stop()
+	for {
+		cmd := makecmd.NewCmd(g.Target, r.options(g, ""))
+		pending := cmd.DetectMode(check)
+		if r.isStopped() {
+			return
+		}
+		if r.cfg.OnDetect != nil {
+			r.cfg.OnDetect(g, cmd.Name(), pending)
+		} else {
+			fmt.Printf("%s\t%s\n", cmd.Name(), strings.Join(pending, " "))
+		}
+	}
+}
+
+// dryRun runs the main loop for DryRun mode. It logs which targets need
+// building, but never builds them. It only returns after stopping.
+func (r *runner) dryRun(g Goal) {
+	check, stop := r.makeCheckChannel()
+	defer stop()
+	cmd := makecmd.NewCmd(g.Target, r.options(g, ""))
+	cmd.DryRunMode(check)
+}
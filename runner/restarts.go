@@ -0,0 +1,53 @@
+package runner
+
+import "time"
+
+const (
+	// restartWindow is the period in which restarts are counted
+	// for the MaxRestarts option.
+	restartWindow = time.Minute
+
+	// minRestartBackoff and maxRestartBackoff are the limits of how long
+	// to wait before restarting, when there are too many restarts.
+	minRestartBackoff = time.Second
+	maxRestartBackoff = time.Minute
+)
+
+// restartLimiter counts how often a goal's make command restarts, and backs
+// off when it restarts more than the limit within the restart window.
+// This stops a build that keeps failing or touching watched files
+// from running make over and over again.
+type restartLimiter struct {
+	max      int
+	restarts []time.Time
+	backoff  time.Duration
+}
+
+// delay records a restart at the given time, and returns how long to wait
+// before restarting. It returns zero when the limit has not been exceeded.
+// The backoff doubles each time the limit is exceeded, and it resets once
+// there have been no restarts for a full restart window.
+func (rl *restartLimiter) delay(now time.Time) time.Duration {
+	if rl.max <= 0 {
+		return 0
+	}
+	recent := rl.restarts[:0]
+	for _, t := range rl.restarts {
+		if now.Sub(t) < restartWindow {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) == 0 {
+		rl.backoff = 0
+	}
+	rl.restarts = append(recent, now)
+	if len(rl.restarts) <= rl.max {
+		return 0
+	}
+	if rl.backoff == 0 {
+		rl.backoff = minRestartBackoff
+	} else if rl.backoff *= 2; rl.backoff > maxRestartBackoff {
+		rl.backoff = maxRestartBackoff
+	}
+	return rl.backoff
+}
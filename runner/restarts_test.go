@@ -0,0 +1,50 @@
+package runner
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRestartLimiter(t *testing.T) {
+	now := time.Now()
+	rl := restartLimiter{max: 2}
+	check := func(after time.Duration, expect time.Duration) {
+		t.Helper()
+		now = now.Add(after)
+		if got := rl.delay(now); got != expect {
+			t.Errorf("Expected a delay of %s, got %s", expect, got)
+		}
+	}
+
+	// Restarts within the limit are not delayed.
+	check(0, 0)
+	check(time.Second, 0)
+
+	// After that, the delay doubles each time.
+	check(time.Second, time.Second)
+	check(time.Second, 2*time.Second)
+	check(time.Second, 4*time.Second)
+
+	// Older restarts fall out of the window, but the backoff remains
+	// until there has been a whole window without restarts.
+	check(restartWindow-time.Second, 0)
+	check(time.Second, 0)
+	check(time.Second, 8*time.Second)
+
+	// It is capped.
+	check(time.Second, 16*time.Second)
+	check(time.Second, 32*time.Second)
+	check(time.Second, maxRestartBackoff)
+	check(time.Second, maxRestartBackoff)
+
+	// And it resets after a stable period.
+	check(restartWindow, 0)
+	check(time.Second, 0)
+	check(time.Second, time.Second)
+
+	// Zero means no limit.
+	rl = restartLimiter{}
+	for i := 0; i < 10; i++ {
+		check(0, 0)
+	}
+}
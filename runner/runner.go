@@ -0,0 +1,368 @@
+// Package runner keeps make targets up to date. It runs make to build
+// them, and then runs it again whenever something has changed. This is
+// the core of Remake, for embedding it in other programs.
+package runner
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/raymondbutcher/remake/makecmd"
+)
+
+const (
+	defaultCheckInterval = 2 * time.Second
+	defaultGracePeriod   = 10 * time.Second
+	errorSleep           = 5 * time.Second
+)
+
+// Mode is how Run manages the goals.
+type Mode int
+
+const (
+	// Watch builds the goals, and builds them again when they change.
+	Watch Mode = iota
+
+	// Once builds the goals until they are up to date, and then stops.
+	Once
+
+	// DetectOnly reports when the goals change, without building them.
+	DetectOnly
+
+	// DryRun logs which targets need building, without building them.
+	DryRun
+)
+
+// A Goal is a target to manage, and the directory to run make in.
+type Goal struct {
+	Target string
+	Dir    string
+}
+
+// String returns the goal's name for display purposes.
+func (g Goal) String() string {
+	if len(g.Target) == 0 {
+		return "default goal"
+	}
+	return g.Target
+}
+
+// Config holds the settings for Run. They mirror Remake's command line
+// options.
+type Config struct {
+	// Mode is how the goals are managed. The default is Watch.
+	Mode Mode
+
+	// Options are the make command options for each goal.
+	Options makecmd.Options
+
+	// GoalOptions returns the make command options for a goal, instead of
+	// using Options. The trigger is the reason for running the command:
+	// "start", "change" or "retry".
+	GoalOptions func(g Goal, trigger string) makecmd.Options
+
+	// CheckInterval is how often to check for changes.
+	// The default is 2 seconds.
+	CheckInterval time.Duration
+
+	// GracePeriod is how long make commands can go without making
+	// progress before they are killed. The default is 10 seconds.
+	GracePeriod time.Duration
+
+	// GraceFor has grace periods for specific targets,
+	// instead of GracePeriod.
+	GraceFor map[string]time.Duration
+
+	// MaxRestarts is how many times a goal can restart within a minute
+	// before it backs off. Zero means no limit.
+	MaxRestarts int
+
+	// Coalesce builds goals that need building at the same time in the
+	// same directory with one make command.
+	Coalesce bool
+
+	// Ready has channels that end the grace period for a goal,
+	// for the ready signal.
+	Ready map[Goal]<-chan bool
+
+	// Stop makes Run kill the make commands and return when it is closed.
+	// It waits for the goroutines that manage the goals to return first,
+	// so no more make commands or queries run after that.
+	Stop <-chan struct{}
+
+	// OnCommand is called with each make command before it starts.
+	OnCommand func(g Goal, cmd *makecmd.Cmd)
+
+	// OnRestart is called when a goal's make command is started again.
+	OnRestart func(g Goal)
+
+	// OnDetect is called when a goal changes in DetectOnly mode, with the
+	// name of the make command's target and the targets that need updating.
+	// The default prints the name and the targets, separated by a tab.
+	OnDetect func(g Goal, name string, pending []string)
+}
+
+// Result is how the last build of a goal went.
+type Result struct {
+	Goal     Goal
+	Finished bool
+	Err      error
+}
+
+// Run manages the targets in the Options directory, or the default goal
+// if there are none. It returns an error if the last build of any target
+// failed. In Watch mode, it only returns after Stop has been closed.
+func Run(cfg Config, targets []string) error {
+	goals := []Goal{}
+	for _, target := range targets {
+		goals = append(goals, Goal{Target: target, Dir: cfg.Options.Dir})
+	}
+	if len(goals) == 0 {
+		goals = append(goals, Goal{Dir: cfg.Options.Dir})
+	}
+	for _, result := range RunGoals(cfg, goals) {
+		if result.Err != nil {
+			return fmt.Errorf("%s: %s", result.Goal, result.Err)
+		}
+	}
+	return nil
+}
+
+// RunGoals manages the goals until Stop is closed or, in Once mode, until
+// they have all finished. It returns how the last build of each goal went.
+func RunGoals(cfg Config, goals []Goal) []Result {
+	if cfg.CheckInterval <= 0 {
+		cfg.CheckInterval = defaultCheckInterval
+	}
+	if cfg.GracePeriod <= 0 {
+		cfg.GracePeriod = defaultGracePeriod
+	}
+	r := &runner{
+		cfg:      cfg,
+		commands: map[Goal]*makecmd.Cmd{},
+		statuses: map[Goal]Result{},
+		batches:  map[string]*buildBatch{},
+		done:     make(chan struct{}),
+	}
+
+	// Start managing each goal as a separate goroutine.
+	var wg sync.WaitGroup
+	for _, g := range goals {
+		wg.Add(1)
+		go func(g Goal) {
+			defer wg.Done()
+			switch cfg.Mode {
+			case Once:
+				r.remakeOnce(g)
+			case DetectOnly:
+				r.detect(g)
+			case DryRun:
+				r.dryRun(g)
+			default:
+				r.remake(g)
+			}
+		}(g)
+	}
+
+	// Let the goroutines work until stopped,
+	// or until they have all finished in Once mode.
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-cfg.Stop:
+	case <-finished:
+	}
+
+	results := make([]Result, len(goals))
+	for i, g := range goals {
+		results[i] = r.getStatus(g)
+	}
+	r.stop()
+	wg.Wait()
+	return results
+}
+
+// runner holds the state of one call to RunGoals.
+type runner struct {
+	cfg Config
+
+	mutex    sync.Mutex
+	commands map[Goal]*makecmd.Cmd
+	statuses map[Goal]Result
+	stopped  bool
+	done     chan struct{}
+
+	batchMutex sync.Mutex
+	batches    map[string]*buildBatch
+}
+
+// setCommand records the make command for a goal, so that it can be killed
+// when stopping. It returns false if that has already happened, in which
+// case the command must not be started.
+func (r *runner) setCommand(g Goal, cmd *makecmd.Cmd) bool {
+	r.mutex.Lock()
+	if r.stopped {
+		r.mutex.Unlock()
+		return false
+	}
+	r.commands[g] = cmd
+	r.mutex.Unlock()
+	if r.cfg.OnCommand != nil {
+		r.cfg.OnCommand(g, cmd)
+	}
+	return true
+}
+
+// stop kills the make commands of all goals, along with their child
+// processes, and stops any more from starting. Closing the done channel
+// closes the check channels, which makes the goal loops return.
+func (r *runner) stop() {
+	r.mutex.Lock()
+	r.stopped = true
+	close(r.done)
+	commands := make([]*makecmd.Cmd, 0, len(r.commands))
+	for _, cmd := range r.commands {
+		commands = append(commands, cmd)
+	}
+	r.mutex.Unlock()
+
+	// Each kill can take up to makecmd.KillTimeout, so do them all at once.
+	var wg sync.WaitGroup
+	for _, cmd := range commands {
+		wg.Add(1)
+		go func(cmd *makecmd.Cmd) {
+			defer wg.Done()
+			cmd.Kill()
+		}(cmd)
+	}
+	wg.Wait()
+}
+
+// isStopped reports whether stop has been called.
+func (r *runner) isStopped() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// sleep waits for a duration, or until stop is called.
+// It returns false if it was stopped.
+func (r *runner) sleep(d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-r.done:
+		return false
+	}
+}
+
+// setStatus records the result of a goal's make command.
+func (r *runner) setStatus(g Goal, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if !r.stopped {
+		r.statuses[g] = Result{Goal: g, Finished: true, Err: err}
+	}
+}
+
+// getStatus returns the result of a goal's last make command.
+func (r *runner) getStatus(g Goal) Result {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if result, found := r.statuses[g]; found {
+		return result
+	}
+	return Result{Goal: g}
+}
+
+// options returns the make command options for a goal. They record the
+// result of each build before calling the OnFinish option.
+func (r *runner) options(g Goal, trigger string) makecmd.Options {
+	opts := r.cfg.Options
+	if r.cfg.GoalOptions != nil {
+		opts = r.cfg.GoalOptions(g, trigger)
+	}
+	opts.Dir = g.Dir
+	onFinish := opts.OnFinish
+	opts.OnFinish = func(cmd *makecmd.Cmd, err error) {
+		r.setStatus(g, err)
+		if onFinish != nil {
+			onFinish(cmd, err)
+		}
+	}
+	return opts
+}
+
+// batchOptions returns the make command options for building
+// a batch of goals with one make command. The first goal leads.
+func (r *runner) batchOptions(goals []Goal, trigger string) makecmd.Options {
+	opts := r.options(goals[0], trigger)
+	onFinish := []func(*makecmd.Cmd, error){opts.OnFinish}
+	for _, g := range goals[1:] {
+		opts.ExtraGoals = append(opts.ExtraGoals, g.Target)
+		onFinish = append(onFinish, r.options(g, trigger).OnFinish)
+	}
+	opts.OnFinish = func(cmd *makecmd.Cmd, err error) {
+		for _, f := range onFinish {
+			f(cmd, err)
+		}
+	}
+	return opts
+}
+
+// gracePeriod returns the grace period for a goal, which is from
+// GraceFor if it has the goal's target, or GracePeriod if not.
+func (r *runner) gracePeriod(g Goal) time.Duration {
+	if d, found := r.cfg.GraceFor[g.Target]; found {
+		return d
+	}
+	return r.cfg.GracePeriod
+}
+
+// makeCheckChannel returns a channel that is populated when it is time to
+// check for changes, based on the check interval. The channel is closed
+// when the stop function is called, or when the runner is stopped.
+func (r *runner) makeCheckChannel() (ch chan struct{}, stop func()) {
+
+	ch = make(chan struct{})
+
+	var checkch <-chan time.Time
+	checkch = time.After(r.cfg.CheckInterval)
+
+	stopch := make(chan struct{})
+	var once sync.Once
+
+	go func() {
+		defer close(ch)
+		for {
+			select {
+			case <-checkch:
+				select {
+				case ch <- struct{}{}:
+				case <-stopch:
+					return
+				case <-r.done:
+					return
+				}
+				checkch = time.After(r.cfg.CheckInterval)
+			case <-stopch:
+				return
+			case <-r.done:
+				return
+			}
+		}
+	}()
+
+	stop = func() {
+		once.Do(func() { close(stopch) })
+	}
+
+	return
+}
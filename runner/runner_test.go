@@ -0,0 +1,234 @@
+package runner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/raymondbutcher/remake/makecmd"
+)
+
+func TestRunOnce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	makefile := "out: in\n\tcp in out\n\nfail:\n\t@exit 3\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "Makefile"), []byte(makefile), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "in"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	finished := 0
+	cfg := Config{
+		Mode: Once,
+		Options: makecmd.Options{
+			Dir: dir,
+			OnFinish: func(cmd *makecmd.Cmd, err error) {
+				finished++
+			},
+		},
+		CheckInterval: 100 * time.Millisecond,
+	}
+	if err := Run(cfg, []string{"out"}); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "out")); err != nil || string(data) != "hello" {
+		t.Errorf("Expected out to be built, got %q and %v", data, err)
+	}
+	if finished != 1 {
+		t.Errorf("Expected OnFinish to be called once, got %d", finished)
+	}
+
+	err = Run(cfg, []string{"fail"})
+	if err == nil || !strings.HasPrefix(err.Error(), "fail: ") {
+		t.Errorf("Expected an error for the fail target, got %v", err)
+	}
+}
+
+func TestRunGoalsStop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	makefile := ".PHONY: server\nserver:\n\t@echo started\n\t@sleep 10\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "Makefile"), []byte(makefile), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	started := make(chan *makecmd.Cmd, 1)
+	cfg := Config{
+		CheckInterval: 100 * time.Millisecond,
+		Stop:          stop,
+		OnCommand: func(g Goal, cmd *makecmd.Cmd) {
+			started <- cmd
+		},
+	}
+	done := make(chan []Result)
+	go func() {
+		done <- RunGoals(cfg, []Goal{{Target: "server", Dir: dir}})
+	}()
+
+	var cmd *makecmd.Cmd
+	select {
+	case cmd = <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the make command to start")
+	}
+	close(stop)
+	select {
+	case results := <-done:
+		if len(results) != 1 || results[0].Finished {
+			t.Errorf("Expected one unfinished result, got %+v", results)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected RunGoals to return after stopping")
+	}
+	if cmd.Duration() == 0 || cmd.Duration() > 5*time.Second {
+		t.Errorf("Expected the make command to have been killed, ran for %s", cmd.Duration())
+	}
+}
+
+func TestCoalesceSlowExtraGoal(t *testing.T) {
+	dir := t.TempDir()
+	// Whichever goal leads, make builds the other one after its own, so the
+	// leader leaves grace mode before the other goal is up to date.
+	makefile := "fast:\n\t@echo fast >> log\n\ttouch fast\n\nslow:\n\t@echo slow >> log\n\tsleep 1\n\ttouch slow\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "Makefile"), []byte(makefile), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	cfg := Config{
+		CheckInterval: 100 * time.Millisecond,
+		Coalesce:      true,
+		Stop:          stop,
+	}
+	done := make(chan []Result)
+	go func() {
+		done <- RunGoals(cfg, []Goal{{Target: "fast", Dir: dir}, {Target: "slow", Dir: dir}})
+	}()
+	time.Sleep(3 * time.Second)
+	close(stop)
+	<-done
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Fields(string(data)); len(got) != 2 {
+		t.Errorf("Expected make to build each goal once but got %v", got)
+	}
+}
+
+func TestRunGoalsStopChecking(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "Makefile"), []byte("out:\n\ttouch out\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Log each time that make runs, including the queries.
+	calls := filepath.Join(dir, "calls")
+	script := filepath.Join(dir, "make")
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\necho >> "+calls+"\nexec make \"$@\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	count := func() int {
+		data, _ := ioutil.ReadFile(calls)
+		return strings.Count(string(data), "\n")
+	}
+
+	for _, mode := range []Mode{Watch, DetectOnly, DryRun} {
+		stop := make(chan struct{})
+		cfg := Config{
+			Mode:          mode,
+			Options:       makecmd.Options{Make: script},
+			CheckInterval: 50 * time.Millisecond,
+			Stop:          stop,
+			OnDetect:      func(g Goal, name string, pending []string) {},
+		}
+		done := make(chan struct{})
+		go func() {
+			RunGoals(cfg, []Goal{{Target: "out", Dir: dir}})
+			close(done)
+		}()
+		time.Sleep(500 * time.Millisecond)
+		close(stop)
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Expected RunGoals to return after stopping in mode %d", mode)
+		}
+		before := count()
+		if before == 0 {
+			t.Errorf("Expected make to run in mode %d", mode)
+		}
+		time.Sleep(300 * time.Millisecond)
+		if after := count(); after != before {
+			t.Errorf("Expected no make commands after stopping in mode %d, but got %d more", mode, after-before)
+		}
+	}
+}
+
+func TestDetectOnly(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "Makefile"), []byte("out: in\n\tcp in out\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour)
+	for _, name := range []string{"in", "out"} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stop := make(chan struct{})
+	detected := make(chan []string, 1)
+	cfg := Config{
+		Mode:          DetectOnly,
+		CheckInterval: 50 * time.Millisecond,
+		Stop:          stop,
+		OnDetect: func(g Goal, name string, pending []string) {
+			if name == "out" {
+				detected <- pending
+			}
+		},
+	}
+	done := make(chan struct{})
+	go func() {
+		RunGoals(cfg, []Goal{{Target: "out", Dir: dir}})
+		close(done)
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	// Changing the prerequisite is reported, and nothing gets built.
+	time.Sleep(300 * time.Millisecond)
+	if err := os.Chtimes(filepath.Join(dir, "in"), time.Now(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case pending := <-detected:
+		if got := strings.Join(pending, ","); got != "out" {
+			t.Errorf("Expected out to be pending but got %s", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the change to be detected")
+	}
+	if info, err := os.Stat(filepath.Join(dir, "out")); err != nil || info.ModTime().After(past.Add(time.Second)) {
+		t.Errorf("Expected out not to be built, got %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/raymondbutcher/remake/logs"
+	"github.com/raymondbutcher/remake/runner"
+)
+
+// onExitTimeout limits how long the -on-exit command can run for.
+const onExitTimeout = 10 * time.Second
+
+// makeTerminateChannel returns a channel that receives
+// a signal when Remake is asked to terminate.
+func makeTerminateChannel() <-chan os.Signal {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+	return ch
+}
+
+// shutdown cleans up before Remake exits, after the make commands have been
+// stopped. It reports how the last build of each goal went, kills any hooks
+// that are still running, stops the -http server, and returns the exit code
+// that Remake should use.
+func shutdown(results []runner.Result) (code int) {
+	if !detectOnly && !dryRun {
+		code = printSummary(results)
+	}
+	stopHooks()
+	stopHTTP()
+	if len(readyDir) != 0 {
+		os.RemoveAll(readyDir)
+	}
+	if len(onExit) != 0 {
+		runOnExit(onExit)
+	}
+	return code
+}
+
+// runOnExit runs the -on-exit command through the shell. Failures are
+// logged but they don't stop Remake from exiting.
+func runOnExit(command string) {
+	ctx, cancel := context.WithTimeout(context.Background(), onExitTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		logs.Errorf("", "Error running -on-exit command: %s", err)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/raymondbutcher/remake/logs"
+	"github.com/raymondbutcher/remake/makecmd"
+)
+
+// httpShutdownTimeout limits how long the -http server can take to stop.
+const httpShutdownTimeout = 2 * time.Second
+
+// goalStats is the information about a goal that is served by -http.
+type goalStats struct {
+	Goal      string   `json:"goal"`
+	Status    string   `json:"status"`
+	Restarts  int      `json:"restarts"`
+	ExitCode  *int     `json:"exit_code"`
+	Duration  float64  `json:"duration"`
+	Pending   []string `json:"pending"`
+	Watched   int      `json:"watched"`
+	WatchDirs []string `json:"watch_dirs"`
+}
+
+var (
+	stats      = map[goal]*goalStats{}
+	statsMutex sync.Mutex
+	httpServer *http.Server
+)
+
+// updateStats changes a goal's stats while holding the lock.
+func updateStats(g goal, update func(s *goalStats)) {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+	s := stats[g]
+	if s == nil {
+		s = &goalStats{Goal: g.String(), Status: "not finished", Pending: []string{}, WatchDirs: []string{}}
+		stats[g] = s
+	}
+	update(s)
+}
+
+// countRestart records that a goal's make command is being started again.
+func countRestart(g goal) {
+	updateStats(g, func(s *goalStats) {
+		s.Restarts++
+	})
+}
+
+// recordBuild records the result of a goal's make command.
+func recordBuild(g goal, duration time.Duration, err error) {
+	code := makecmd.ExitCode(err)
+	updateStats(g, func(s *goalStats) {
+		s.Status = "ok"
+		if err != nil {
+			s.Status = "failed"
+		}
+		s.ExitCode = &code
+		s.Duration = duration.Seconds()
+	})
+}
+
+// recordCheck records what a goal's make command found at its last check.
+func recordCheck(g goal, cmd *makecmd.Cmd) {
+	pending := cmd.PendingTargets()
+	if pending == nil {
+		pending = []string{}
+	}
+	watched := cmd.WatchedFiles()
+	// This uses the database from the check, so make doesn't run again.
+	dirs, err := cmd.GetWatchDirs()
+	updateStats(g, func(s *goalStats) {
+		s.Pending = pending
+		s.Watched = watched
+		if err == nil && dirs != nil {
+			s.WatchDirs = dirs
+		}
+	})
+}
+
+// startHTTP starts the -http server, which serves the stats of each goal
+// as JSON from /stats, and responds to /healthz while Remake is running.
+func startHTTP(addr string, goals []goal) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"goals": statsList(goals),
+		})
+	})
+	httpServer = &http.Server{Handler: mux}
+	go func() {
+		if err := httpServer.Serve(listener); err != http.ErrServerClosed {
+			logs.Errorf("", "Error serving -http: %s", err)
+		}
+	}()
+	return nil
+}
+
+// statsList returns a copy of the stats for each goal, in order.
+func statsList(goals []goal) []goalStats {
+	list := make([]goalStats, len(goals))
+	for i, g := range goals {
+		updateStats(g, func(s *goalStats) {
+			list[i] = *s
+		})
+	}
+	return list
+}
+
+// stopHTTP stops the -http server, if it was started.
+func stopHTTP() {
+	if httpServer == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancel()
+	httpServer.Shutdown(ctx)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/raymondbutcher/remake/makecmd"
+)
+
+func TestRecordBuild(t *testing.T) {
+	g := goal{target: "stats-test"}
+	countRestart(g)
+	recordBuild(g, 1500*time.Millisecond, exec.Command("sh", "-c", "exit 3").Run())
+
+	s := statsList([]goal{g})[0]
+	if s.Status != "failed" || s.Restarts != 1 || s.Duration != 1.5 {
+		t.Errorf("Unexpected stats: %+v", s)
+	}
+	if s.ExitCode == nil || *s.ExitCode != 3 {
+		t.Errorf("Expected exit code 3, got %v", s.ExitCode)
+	}
+
+	recordBuild(g, time.Second, nil)
+	if s := statsList([]goal{g})[0]; s.Status != "ok" || *s.ExitCode != 0 {
+		t.Errorf("Unexpected stats: %+v", s)
+	}
+}
+
+func TestRecordCheck(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "src"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	makefile := "out: src/in gen/new\n\ttouch out\n\ngen/new:\n\tmkdir -p gen && touch $@\n"
+	for name, content := range map[string]string{"Makefile": makefile, "src/in": ""} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	g := goal{target: "out", dir: dir}
+	cmd := makecmd.NewCmd(g.target, makecmd.Options{Dir: dir})
+	if err := cmd.UpdateProgress(); err != nil {
+		t.Fatal(err)
+	}
+	recordCheck(g, cmd)
+
+	// The gen directory doesn't exist yet, so its parent is watched.
+	s := statsList([]goal{g})[0]
+	if got := strings.Join(s.WatchDirs, ","); got != ".,src" {
+		t.Errorf("Expected .,src but got %s", got)
+	}
+}
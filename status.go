@@ -0,0 +1,23 @@
+package main
+
+import (
+	"github.com/raymondbutcher/remake/logs"
+	"github.com/raymondbutcher/remake/runner"
+)
+
+// printSummary logs the result of the last build of each goal,
+// and returns the exit code that Remake should use.
+func printSummary(results []runner.Result) (code int) {
+	for _, result := range results {
+		g := fromRunnerGoal(result.Goal)
+		if !result.Finished {
+			logs.Warnf(g.target, "%s: not finished", g)
+		} else if result.Err != nil {
+			logs.Errorf(g.target, "%s: failed: %s", g, result.Err)
+			code = 1
+		} else {
+			logs.Infof(g.target, "%s: ok", g)
+		}
+	}
+	return code
+}